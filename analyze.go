@@ -0,0 +1,174 @@
+package p0f
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math"
+	"time"
+)
+
+// limits of clock frequency measurement of p0f: interval between packets and frequency in Hz
+const (
+	minClockInterval  = 25 * time.Millisecond
+	maxClockInterval  = 10 * time.Minute
+	minClockFrequency = 0.7
+	maxClockFrequency = 1500
+)
+
+// Sample is a packet of the host and time it is captured
+type Sample struct {
+	Observed *signature.Signature
+	// own timestamp (TSval) of timestamps option, valid if HasTimestamp
+	Timestamp    uint32
+	HasTimestamp bool
+	Time         time.Time
+}
+
+// NewSample observes IPv4 or IPv6 packet captured at the time, see Observe
+func NewSample(ip gopacket.NetworkLayer, tcp *layers.TCP, at time.Time) (*Sample, error) {
+
+	sample := &Sample{Time: at}
+
+	switch ip := ip.(type) {
+	case *layers.IPv4:
+		sample.Observed = Observe(ip, tcp)
+	case *layers.IPv6:
+		sample.Observed = ObserveIpv6(ip, tcp)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrIpVersion, ip.LayerType())
+	}
+
+	for _, option := range tcp.Options {
+		if option.OptionType == layers.TCPOptionKindTimestamps && len(option.OptionData) >= 8 {
+			sample.Timestamp = binary.BigEndian.Uint32(option.OptionData[:4])
+			sample.HasTimestamp = true
+			break
+		}
+	}
+
+	return sample, nil
+}
+
+// HostInfo is metadata of the host derived from its packets, see Analyze
+type HostInfo struct {
+	InitialTTL int
+	// initial TTL is guessed from TTL of the packet if the signature is not known or has random TTL
+	GuessedTTL bool
+	// number of hops between the host and the observer
+	Distance int
+
+	// MSS of the packet and the link of its MTU, Link is nil if MTU is not known
+	MSS  int
+	Link *signature.Link
+
+	// clock frequency of timestamps in Hz, zero if it is not known
+	ClockFrequency int
+	// time since the clock of timestamps is started, usually the boot of the host.
+	// Timestamps wrap around every UptimeModulo, so uptime is known modulo it.
+	Uptime       time.Duration
+	UptimeModulo time.Duration
+}
+
+// Analyze returns metadata of the host as p0f reports it. The matched signature gives initial TTL,
+// database gives link types of [mtu] section, the second packet of the same host is used
+// to measure clock of timestamps, uptime is of the later packet. Signature, database and
+// the second packet are optional. Empty info is returned if the first packet is not observed.
+func Analyze(db *signature.Database, sig *signature.Signature, first *Sample, second *Sample) *HostInfo {
+
+	if first == nil || first.Observed == nil {
+		return &HostInfo{}
+	}
+
+	observed := first.Observed
+	info := &HostInfo{MSS: observed.MaximumSegmentSize}
+
+	if sig != nil && !sig.RandomTTL {
+		info.InitialTTL = sig.InitialTTL
+	} else {
+		info.InitialTTL = GuessInitialTTL(observed.InitialTTL)
+		info.GuessedTTL = true
+	}
+	info.Distance = max(info.InitialTTL-observed.InitialTTL, 0)
+
+	if db != nil && info.MSS > 0 {
+		info.Link = db.Link(info.MSS, observed.IpVersion)
+	}
+
+	if second != nil {
+		if frequency, ok := ClockFrequency(first, second); ok {
+			later := second
+			if first.Time.After(second.Time) {
+				later = first
+			}
+			info.setClock(frequency, later.Timestamp)
+		}
+	}
+
+	return info
+}
+
+// setClock sets clock frequency and uptime by the timestamp
+func (info *HostInfo) setClock(frequency int, timestamp uint32) {
+	info.ClockFrequency = frequency
+	info.Uptime = time.Duration(float64(timestamp) / float64(frequency) * float64(time.Second))
+	info.UptimeModulo = time.Duration(float64(math.MaxUint32+1) / float64(frequency) * float64(time.Second))
+}
+
+// GuessInitialTTL returns the nearest of common initial TTLs (32, 64, 128, 255) not less than TTL
+func GuessInitialTTL(ttl int) int {
+	switch {
+	case ttl <= 32:
+		return 32
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	}
+	return 255
+}
+
+// ClockFrequency returns frequency of timestamps of two packets of the same host in Hz,
+// rounded as p0f does. Packets have to be captured 25ms to 10 minutes apart.
+func ClockFrequency(first *Sample, second *Sample) (int, bool) {
+
+	if !first.HasTimestamp || !second.HasTimestamp {
+		return 0, false
+	}
+
+	interval := second.Time.Sub(first.Time)
+	if interval < 0 {
+		first, second, interval = second, first, -interval
+	}
+	if interval < minClockInterval || interval > maxClockInterval {
+		return 0, false
+	}
+
+	// timestamps wrap around, so the difference is modulo 2^32
+	ticks := second.Timestamp - first.Timestamp
+	frequency := float64(ticks) / interval.Seconds()
+	if frequency < minClockFrequency || frequency > maxClockFrequency {
+		return 0, false
+	}
+
+	return roundClockFrequency(int(frequency)), true
+}
+
+// roundClockFrequency rounds frequency to common values of clocks, e.g. 100, 250 or 1000 Hz
+func roundClockFrequency(frequency int) int {
+	switch {
+	case frequency == 0:
+		return 1
+	case frequency <= 10:
+		return frequency
+	case frequency <= 50:
+		return (frequency + 3) / 5 * 5
+	case frequency <= 100:
+		return (frequency + 7) / 10 * 10
+	case frequency <= 500:
+		return (frequency + 33) / 50 * 50
+	}
+	return (frequency + 67) / 100 * 100
+}
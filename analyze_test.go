@@ -0,0 +1,126 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0")
+	db := signature.DefaultDatabase()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sample := func(ts uint32, at time.Time) *Sample {
+		spoofer, err := NewSpoofer(
+			WithSignature(sig),
+			WithTTLDistance(7),
+			WithHintPolicies(HintPolicies{
+				MSS: HintFromConfig, MSSValue: 1452,
+				Timestamps: HintFromConfig, TimestampValue: ts,
+			}),
+		)
+		assert.NoError(t, err)
+
+		ipv4, tcp := randomPacket(false)
+		assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+
+		s, err := NewSample(ipv4, tcp, at)
+		assert.NoError(t, err)
+		return s
+	}
+
+	// 1000 Hz clock started 2 hours ago
+	first := sample(7_200_000, start)
+	second := sample(7_210_012, start.Add(10*time.Second))
+	assert.True(t, first.HasTimestamp)
+	assert.Equal(t, uint32(7_200_000), first.Timestamp)
+
+	info := Analyze(db, sig, first, second)
+	assert.Equal(t, 64, info.InitialTTL)
+	assert.False(t, info.GuessedTTL)
+	assert.Equal(t, 7, info.Distance)
+	assert.Equal(t, 1452, info.MSS)
+	if assert.NotNil(t, info.Link) {
+		assert.Equal(t, "DSL", info.Link.Label)
+	}
+	assert.Equal(t, 1000, info.ClockFrequency)
+	assert.Equal(t, 2*time.Hour+10*time.Second+12*time.Millisecond, info.Uptime)
+	assert.InDelta(t, 49.7, info.UptimeModulo.Hours()/24, 0.1)
+
+	// uptime is of the later packet if packets are out of order
+	info = Analyze(db, sig, second, first)
+	assert.Equal(t, 1000, info.ClockFrequency)
+	assert.Equal(t, 2*time.Hour+10*time.Second+12*time.Millisecond, info.Uptime)
+
+	// packet which is not observed
+	assert.Equal(t, &HostInfo{}, Analyze(db, sig, &Sample{}, second))
+	assert.Equal(t, &HostInfo{}, Analyze(db, sig, nil, nil))
+
+	// single packet without signature and database
+	info = Analyze(nil, nil, first, nil)
+	assert.Equal(t, 64, info.InitialTTL)
+	assert.True(t, info.GuessedTTL)
+	assert.Equal(t, 7, info.Distance)
+	assert.Nil(t, info.Link)
+	assert.Zero(t, info.ClockFrequency)
+	assert.Zero(t, info.Uptime)
+}
+
+func TestClockFrequency(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(ts uint32, after time.Duration) *Sample {
+		return &Sample{Timestamp: ts, HasTimestamp: true, Time: start.Add(after)}
+	}
+
+	var testData = []struct {
+		first     *Sample
+		second    *Sample
+		frequency int
+		ok        bool
+	}{
+		{sample(1000, 0), sample(2000, time.Second), 1000, true},
+		{sample(1000, 0), sample(1251, time.Second), 250, true},
+		{sample(1000, 0), sample(1098, time.Second), 100, true},
+		{sample(10, 0), sample(20, 10*time.Second), 1, true},
+		// swapped packets
+		{sample(2000, time.Second), sample(1000, 0), 1000, true},
+		// wrap around
+		{sample(0xFFFFFF00, 0), sample(0x300, time.Second), 1000, true},
+		// too close or too far apart
+		{sample(1000, 0), sample(1010, 10*time.Millisecond), 0, false},
+		{sample(1000, 0), sample(1000+11*60*1000, 11*time.Minute), 0, false},
+		// clock is too slow, too fast or goes backwards
+		{sample(1000, 0), sample(1000, time.Second), 0, false},
+		{sample(1000, 0), sample(1000+10_000, time.Second), 0, false},
+		{sample(2000, 0), sample(1000, time.Second), 0, false},
+		// no timestamps
+		{&Sample{Time: start}, sample(2000, time.Second), 0, false},
+	}
+
+	for i, item := range testData {
+		frequency, ok := ClockFrequency(item.first, item.second)
+		assert.Equal(t, item.ok, ok, i)
+		assert.Equal(t, item.frequency, frequency, i)
+	}
+}
+
+func TestGuessInitialTTL(t *testing.T) {
+	for ttl, expected := range map[int]int{1: 32, 32: 32, 33: 64, 60: 64, 100: 128, 128: 128, 129: 255, 255: 255} {
+		assert.Equal(t, expected, GuessInitialTTL(ttl), ttl)
+	}
+}
+
+func TestNewSample(t *testing.T) {
+	_, err := NewSample(&layers.IPv4{}, &layers.TCP{}, time.Now())
+	assert.NoError(t, err)
+	sample, err := NewSample(&layers.IPv6{HopLimit: 60}, &layers.TCP{}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, signature.IpVersion6, sample.Observed.IpVersion)
+	assert.False(t, sample.HasTimestamp)
+}
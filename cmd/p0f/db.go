@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"os"
+	"strings"
+)
+
+const dbUsage = `usage: p0f db <command> [arguments]
+
+commands:
+  merge [-dedup] [-default] file...  print merged database, files go in the given order
+  dups [-default] file...            print exact and equivalent duplicates
+  conflicts [-default] file...       print signatures mapped to more than one label
+  diff old new                       print records removed from old and added to new,
+                                     "-" is the embedded database
+`
+
+var errUsage = errors.New("invalid arguments, see 'p0f db help'")
+
+func runDb(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return errUsage
+	}
+
+	flags := flag.NewFlagSet("db "+args[0], flag.ContinueOnError)
+	withDefault := flags.Bool("default", false, "merge with the embedded database")
+	dedup := flags.Bool("dedup", false, "remove duplicates")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	// flags after file names are not parsed by the flag package
+	for _, arg := range flags.Args() {
+		if arg != "-" && strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unknown flag %s after file names: %w", arg, errUsage)
+		}
+	}
+
+	switch args[0] {
+	case "merge":
+		db, err := loadDatabases(flags.Args(), *withDefault)
+		if err != nil {
+			return err
+		}
+		if *dedup {
+			db = db.Dedup()
+		}
+		_, err = db.WriteTo(os.Stdout)
+		return err
+
+	case "dups":
+		db, err := loadDatabases(flags.Args(), *withDefault)
+		if err != nil {
+			return err
+		}
+		for _, d := range db.Duplicates() {
+			fmt.Printf("%s: [tcp:%s] %s duplicate of %s: %s = %s\n",
+				location(d.Duplicate), d.Duplicate.Direction, d.Kind, location(d.Original), d.Duplicate.Label, d.Duplicate.Raw)
+		}
+		return nil
+
+	case "conflicts":
+		db, err := loadDatabases(flags.Args(), *withDefault)
+		if err != nil {
+			return err
+		}
+		for _, c := range db.Conflicts() {
+			fmt.Printf("[tcp:%s] %s\n", c.Direction, c.Signature)
+			for _, r := range c.Records {
+				fmt.Printf("\t%s: %s\n", location(r), r.Label)
+			}
+		}
+		return nil
+
+	case "diff":
+		// databases are compared as they are
+		if flags.NArg() != 2 || *withDefault || *dedup {
+			return errUsage
+		}
+		previous, err := loadDatabases(flags.Args()[:1], false)
+		if err != nil {
+			return err
+		}
+		current, err := loadDatabases(flags.Args()[1:], false)
+		if err != nil {
+			return err
+		}
+		for _, entry := range signature.Diff(previous, current) {
+			fmt.Println(entry)
+		}
+		return nil
+
+	case "help":
+		fmt.Print(dbUsage)
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, dbUsage)
+	return errUsage
+}
+
+// loadDatabases reads and merges databases, "-" is the embedded database.
+// Line numbers are reported per file, so every file is read separately.
+func loadDatabases(paths []string, withDefault bool) (*signature.Database, error) {
+
+	if len(paths) == 0 && !withDefault {
+		return nil, errUsage
+	}
+
+	parser := signature.Parser{}
+	result := &signature.Database{}
+
+	for _, path := range paths {
+		if path == "-" {
+			result = result.Merge(signature.DefaultDatabase())
+			continue
+		}
+
+		db, err := parser.LoadDatabase(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result = result.Merge(db)
+	}
+
+	if withDefault {
+		result = result.Merge(signature.DefaultDatabase())
+	}
+
+	return result, nil
+}
+
+// location returns file:line of the record, "-" is the embedded database
+func location(r *signature.Record) string {
+	file := r.File
+	if file == "" {
+		file = "-"
+	}
+	return fmt.Sprintf("%s:%d", file, r.Line)
+}
@@ -0,0 +1,41 @@
+// Command p0f is a tool for p0f signature databases
+//
+//	p0f db merge [-dedup] [-default] file...
+//	p0f db dups [-default] file...
+//	p0f db conflicts [-default] file...
+//	p0f db diff old new
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: p0f <command> [arguments]
+
+commands:
+  db    merge, dedup and compare signature databases
+`
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "db":
+		err = runDb(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "p0f:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"slices"
+	"strings"
+)
+
+// fields of mismatches of Consistency
+const (
+	FieldClass = "class"
+	FieldName  = "name"
+)
+
+// UserAgentRule maps User-Agent to operating systems of TCP labels
+type UserAgentRule struct {
+	// case-insensitive substring of User-Agent, e.g. "Windows NT"
+	Token string `json:"token" yaml:"token"`
+	// OS class of labels, e.g. "win" or "unix"
+	Class string `json:"class" yaml:"class"`
+	// names of labels, any name of the class is consistent if empty
+	Names []string `json:"names,omitempty" yaml:"names,omitempty,flow"`
+}
+
+// DefaultUserAgentRules maps common User-Agent platforms to labels of the embedded database,
+// rules are checked in order, e.g. Android goes before Linux as its User-Agent contains both
+var DefaultUserAgentRules = []UserAgentRule{
+	{Token: "Windows", Class: "win", Names: []string{"Windows"}},
+	{Token: "Android", Class: "unix", Names: []string{"Linux"}},
+	{Token: "CrOS", Class: "unix", Names: []string{"Linux"}},
+	{Token: "iPhone", Class: "unix", Names: []string{"iOS", "Mac OS X", "MacOS X"}},
+	{Token: "iPad", Class: "unix", Names: []string{"iOS", "Mac OS X", "MacOS X"}},
+	// iPad requests desktop sites as Macintosh
+	{Token: "Macintosh", Class: "unix", Names: []string{"Mac OS X", "MacOS X", "iOS"}},
+	{Token: "FreeBSD", Class: "unix", Names: []string{"FreeBSD"}},
+	{Token: "OpenBSD", Class: "unix", Names: []string{"OpenBSD"}},
+	{Token: "SunOS", Class: "unix", Names: []string{"Solaris"}},
+	{Token: "Linux", Class: "unix", Names: []string{"Linux"}},
+}
+
+// Consistency is the result of comparison of User-Agent with TCP label, see ConsistencyChecker.Check
+type Consistency struct {
+	// rule of User-Agent, nil if there is no rule for it
+	Rule *UserAgentRule
+	// label of the matched TCP signature, nil if the signature is not known
+	Label *signature.Label
+	// consistency is not known if User-Agent has no rule, the signature is not known or
+	// the label is of application ("!" class)
+	Known bool
+	// 1 if User-Agent is consistent with the label, 0.5 if only OS class is the same, 0 if the class differs
+	Score      float64
+	Mismatches []signature.Mismatch
+}
+
+// Spoofed reports whether User-Agent contradicts the label of TCP signature
+func (c *Consistency) Spoofed() bool {
+	return c.Known && len(c.Mismatches) > 0
+}
+
+// ConsistencyChecker compares User-Agent of HTTP requests with labels of TCP signatures of the clients,
+// e.g. Windows Chrome User-Agent sent by Linux TCP stack
+type ConsistencyChecker struct {
+	rules []UserAgentRule
+}
+
+// NewConsistencyChecker creates checker with the rules, DefaultUserAgentRules are used if rules are empty
+func NewConsistencyChecker(rules []UserAgentRule) *ConsistencyChecker {
+
+	if len(rules) == 0 {
+		rules = DefaultUserAgentRules
+	}
+
+	return &ConsistencyChecker{rules: rules}
+}
+
+// Check compares User-Agent with the label of the matched record, record may be nil,
+// see signature.Database.Match
+func (c *ConsistencyChecker) Check(record *signature.Record, userAgent string) *Consistency {
+
+	result := &Consistency{Score: 0.5}
+	if record != nil {
+		result.Label = record.Label
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for i := range c.rules {
+		if strings.Contains(userAgent, strings.ToLower(c.rules[i].Token)) {
+			result.Rule = &c.rules[i]
+			break
+		}
+	}
+
+	label, rule := result.Label, result.Rule
+	if label == nil || rule == nil || label.Class == "!" {
+		return result
+	}
+
+	result.Known = true
+
+	if !strings.EqualFold(label.Class, rule.Class) {
+		result.Score = 0
+		result.Mismatches = append(result.Mismatches, signature.Mismatch{Field: FieldClass, Expected: rule.Class, Observed: label.Class})
+	}
+
+	if len(rule.Names) > 0 && !slices.ContainsFunc(rule.Names, func(name string) bool {
+		return strings.EqualFold(name, label.Name)
+	}) {
+		result.Mismatches = append(result.Mismatches, signature.Mismatch{Field: FieldName, Expected: strings.Join(rule.Names, "|"), Observed: label.Name})
+	}
+
+	if len(result.Mismatches) == 0 {
+		result.Score = 1
+	}
+
+	return result
+}
@@ -0,0 +1,72 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConsistencyChecker(t *testing.T) {
+
+	const (
+		windowsChrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+		androidChrome = "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+		iphoneSafari  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+		curl          = "curl/8.4.0"
+	)
+
+	parser := signature.Parser{}
+	record := func(label string) *signature.Record {
+		l, err := parser.ParseLabel(label)
+		assert.NoError(t, err)
+		return &signature.Record{Label: l}
+	}
+
+	checker := NewConsistencyChecker(nil)
+
+	var testData = []struct {
+		label      string
+		userAgent  string
+		known      bool
+		score      float64
+		mismatches []string
+	}{
+		{"s:win:Windows:7 or 8", windowsChrome, true, 1, nil},
+		{"s:unix:Linux:3.11 and newer", windowsChrome, true, 0, []string{FieldClass, FieldName}},
+		{"s:unix:Linux:(Android)", androidChrome, true, 1, nil},
+		{"s:unix:FreeBSD:9.x", androidChrome, true, 0.5, []string{FieldName}},
+		{"s:unix:iOS:iPhone or iPad", iphoneSafari, true, 1, nil},
+		{"s:unix:MacOS X:10.9 or newer (sometimes iPhone or iPad)", iphoneSafari, true, 1, nil},
+		{"s:win:Windows:XP", iphoneSafari, true, 0, []string{FieldClass, FieldName}},
+		// no rule for User-Agent and application labels
+		{"s:unix:Linux:3.11 and newer", curl, false, 0.5, nil},
+		{"s:!:NMap:SYN scan", windowsChrome, false, 0.5, nil},
+	}
+
+	for _, item := range testData {
+		result := checker.Check(record(item.label), item.userAgent)
+		assert.Equal(t, item.known, result.Known, item.label)
+		assert.Equal(t, item.score, result.Score, item.label)
+		assert.Equal(t, item.known && len(item.mismatches) > 0, result.Spoofed(), item.label)
+
+		var fields []string
+		for _, mismatch := range result.Mismatches {
+			fields = append(fields, mismatch.Field)
+		}
+		assert.Equal(t, item.mismatches, fields, item.label)
+	}
+
+	// unknown signature
+	result := checker.Check(nil, windowsChrome)
+	assert.False(t, result.Known)
+	assert.Equal(t, "Windows", result.Rule.Token)
+	assert.Nil(t, result.Label)
+
+	mismatch := checker.Check(record("s:unix:Linux:3.x"), windowsChrome).Mismatches[0]
+	assert.Equal(t, "class: expected 'win', observed 'unix'", mismatch.String())
+
+	// custom rules
+	checker = NewConsistencyChecker([]UserAgentRule{{Token: "curl", Class: "unix"}})
+	assert.Equal(t, 1.0, checker.Check(record("s:unix:FreeBSD:9.x"), curl).Score)
+	assert.False(t, checker.Check(record("s:win:Windows:XP"), windowsChrome).Known)
+}
@@ -0,0 +1,350 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"golang.org/x/net/bpf"
+	"slices"
+)
+
+// offsets of IP header in captured frames
+const (
+	LinkOffsetRaw      uint32 = 0
+	LinkOffsetEthernet uint32 = 14
+)
+
+// AcceptLength is the number of bytes of the matching packet returned by BPF program
+const AcceptLength = 0x40000
+
+// maximum number of instructions skipped by conditional jump
+const maxSkip = 0xFF
+
+// CompileBPF compiles the signature to classic BPF program, see Build and Rule.BPF
+func CompileBPF(sig *signature.Signature, direction signature.Direction, linkOffset uint32) ([]bpf.Instruction, error) {
+
+	rule, err := Build(sig, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.BPF(linkOffset)
+}
+
+// BPF returns classic BPF program which accepts packets matching all conditions, IP header starts
+// at linkOffset of the captured frame. Use bpf.Assemble to get raw instructions for SO_ATTACH_FILTER.
+func (r *Rule) BPF(linkOffset uint32) ([]bpf.Instruction, error) {
+
+	c := &bpfCompiler{linkOffset: linkOffset}
+	conditions := mergeConditions(r.Conditions)
+
+	tests := make([][]bpf.Instruction, 0, len(conditions))
+	for _, condition := range conditions {
+		test, err := c.test(condition)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+
+	// tests jump to the rejection at the end of program if the condition is false,
+	// rejection is repeated after the test if it is too far and skipped if the condition is true
+	program := []bpf.Instruction{bpf.RetConstant{Val: AcceptLength}, bpf.RetConstant{Val: 0}}
+	reject := 1
+
+	for i := len(tests) - 1; i >= 0; i-- {
+		if reject > maxSkip {
+			program = append([]bpf.Instruction{bpf.Jump{Skip: 1}, bpf.RetConstant{Val: 0}}, program...)
+			reject = 1
+		}
+
+		test := setJump(tests[i], 0, uint8(reject))
+		program = append(test, program...)
+		reject += len(test)
+	}
+
+	return program, nil
+}
+
+// mergeConditions returns conditions with equality of bytes of the same header merged to loads
+// of 4, 2 or 1 bytes, e.g. kind and length of TCP option, followed by the rest of conditions
+func mergeConditions(conditions []Condition) []Condition {
+
+	type maskedByte struct {
+		mask, value uint32
+	}
+	type header struct {
+		field Field
+		bytes map[uint32]maskedByte
+	}
+
+	var headers []*header
+	var rest []Condition
+
+	find := func(field Field) *header {
+		for _, h := range headers {
+			if h.field.Header == field.Header && slices.Equal(h.field.Lengths, field.Lengths) {
+				return h
+			}
+		}
+		h := &header{field: Field{Header: field.Header, Lengths: field.Lengths}, bytes: map[uint32]maskedByte{}}
+		headers = append(headers, h)
+		return h
+	}
+
+	for _, condition := range conditions {
+		if condition.Kind != KindValue || condition.Op != OpEqual {
+			rest = append(rest, condition)
+			continue
+		}
+
+		field := condition.Field
+		mask := field.Mask
+		if mask == 0 {
+			mask = 0xFFFFFFFF
+		}
+
+		// bytes of the field in network byte order, conditions on the same bits with different values are kept
+		h := find(field)
+		merged := map[uint32]maskedByte{}
+		for i := uint32(0); i < field.Size; i++ {
+			shift := (field.Size - i - 1) * 8
+			b := maskedByte{mask: mask >> shift & 0xFF, value: condition.Value >> shift & 0xFF}
+			if existing, found := h.bytes[field.Offset+i]; found {
+				if existing.mask&b.mask != 0 && existing.value&b.mask != b.value&existing.mask {
+					merged = nil
+					break
+				}
+				b = maskedByte{mask: existing.mask | b.mask, value: existing.value | b.value}
+			}
+			merged[field.Offset+i] = b
+		}
+
+		if merged == nil {
+			rest = append(rest, condition)
+			continue
+		}
+		for offset, b := range merged {
+			h.bytes[offset] = b
+		}
+	}
+
+	result := make([]Condition, 0, len(conditions))
+	for _, h := range headers {
+
+		offsets := make([]uint32, 0, len(h.bytes))
+		for offset := range h.bytes {
+			offsets = append(offsets, offset)
+		}
+		slices.Sort(offsets)
+
+		for len(offsets) > 0 {
+			// the longest load of consecutive bytes
+			size := uint32(1)
+			for _, n := range []uint32{4, 2} {
+				if len(offsets) >= int(n) && offsets[n-1] == offsets[0]+n-1 {
+					size = n
+					break
+				}
+			}
+
+			field := h.field
+			field.Offset, field.Size = offsets[0], size
+			var value uint32
+			for _, offset := range offsets[:size] {
+				field.Mask = field.Mask<<8 | h.bytes[offset].mask
+				value = value<<8 | h.bytes[offset].value
+			}
+			if field.Mask == 1<<(size*8)-1 {
+				field.Mask = 0
+			}
+
+			result = append(result, Condition{Kind: KindValue, Field: field, Op: OpEqual, Value: value})
+			offsets = offsets[size:]
+		}
+	}
+
+	return append(result, rest...)
+}
+
+type bpfCompiler struct {
+	linkOffset uint32
+	// lengths of options the register X is moved by after the start of TCP header, nil if X is not known
+	x []uint32
+}
+
+// test returns instructions evaluating the condition, the last one is a jump to be set by the caller
+func (c *bpfCompiler) test(condition Condition) ([]bpf.Instruction, error) {
+
+	switch condition.Kind {
+	case KindValue:
+		load := c.load(condition.Field)
+		return append(load, jumpIf(condition.Op, condition.Value)), nil
+
+	case KindModulo:
+		load := c.load(condition.Field)
+		load = append(load, bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: condition.Value})
+		return append(load, jumpIf(OpEqual, 0)), nil
+
+	case KindField:
+		// M[0] = Other * Factor + Value
+		program := c.load(condition.Other)
+		program = append(program, bpf.ALUOpConstant{Op: bpf.ALUOpMul, Val: condition.Factor})
+		if condition.Value != 0 {
+			program = append(program, bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: condition.Value})
+		}
+		program = append(program, bpf.StoreScratch{Src: bpf.RegA, N: 0})
+		program = append(program, c.load(condition.Field)...)
+		program = append(program, bpf.LoadScratch{Dst: bpf.RegX, N: 0})
+		c.x = nil
+		return append(program, jumpIfX(condition.Op)), nil
+
+	case KindHeaderLength:
+		// M[0] = offset of the end of options from the start of IP header
+		program := c.tcpOffset(condition.Field.Lengths)
+		program = append(program,
+			bpf.TXA{},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: condition.Field.Offset},
+			bpf.StoreScratch{Src: bpf.RegA, N: 0},
+		)
+		program = append(program, c.headersLength()...)
+		program = append(program, bpf.LoadScratch{Dst: bpf.RegX, N: 0})
+		c.x = nil
+		return append(program, jumpIfX(condition.Op)), nil
+
+	case KindPayloadLength:
+		program := c.headersLength()
+		program = append(program,
+			bpf.TAX{},
+			bpf.LoadAbsolute{Off: c.linkOffset + 2, Size: 2},
+			bpf.ALUOpX{Op: bpf.ALUOpSub},
+		)
+		c.x = nil
+		return append(program, jumpIf(condition.Op, condition.Value)), nil
+
+	case KindAny:
+		program, err := c.testAny(condition.Any)
+		// tests of the group are skipped, so X depends on the path
+		c.x = nil
+		return program, err
+	}
+
+	return nil, fmt.Errorf("unknown condition kind %d", condition.Kind)
+}
+
+// testAny jumps over the rest of tests if any of them is true
+func (c *bpfCompiler) testAny(conditions []Condition) ([]bpf.Instruction, error) {
+
+	tests := make([][]bpf.Instruction, 0, len(conditions))
+	for _, condition := range conditions {
+		test, err := c.test(condition)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+
+	// jump of the last test is set by the caller, the rest skip following tests if true
+	var program []bpf.Instruction
+	skip := 0
+	for i := len(tests) - 1; i >= 0; i-- {
+		test := tests[i]
+		if i < len(tests)-1 {
+			if skip > maxSkip {
+				return nil, fmt.Errorf("too many conditions in group: %d", len(conditions))
+			}
+			test = setJump(test, uint8(skip), 0)
+		}
+		skip += len(test)
+		program = append(test, program...)
+	}
+
+	return program, nil
+}
+
+// load returns instructions loading the field to register A
+func (c *bpfCompiler) load(field Field) []bpf.Instruction {
+
+	var program []bpf.Instruction
+
+	if field.Header == HeaderIP {
+		program = append(program, bpf.LoadAbsolute{Off: c.linkOffset + field.Offset, Size: int(field.Size)})
+	} else {
+		program = c.tcpOffset(field.Lengths)
+		program = append(program, bpf.LoadIndirect{Off: c.linkOffset + field.Offset, Size: int(field.Size)})
+	}
+
+	if field.Mask != 0 {
+		program = append(program, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: field.Mask})
+	}
+
+	return program
+}
+
+// tcpOffset returns instructions setting register X to the offset of TCP header from the start of IP header,
+// moved by values of length bytes. X is kept if it is already moved by the same lengths.
+func (c *bpfCompiler) tcpOffset(lengths []uint32) []bpf.Instruction {
+
+	var program []bpf.Instruction
+
+	moved := 0
+	if c.x != nil && len(c.x) <= len(lengths) && slices.Equal(c.x, lengths[:len(c.x)]) {
+		moved = len(c.x)
+	} else {
+		program = append(program, bpf.LoadMemShift{Off: c.linkOffset})
+	}
+
+	for _, offset := range lengths[moved:] {
+		program = append(program,
+			bpf.LoadIndirect{Off: c.linkOffset + offset, Size: 1},
+			bpf.ALUOpX{Op: bpf.ALUOpAdd},
+			bpf.TAX{},
+		)
+	}
+
+	c.x = append([]uint32{}, lengths...)
+	return program
+}
+
+// headersLength returns instructions loading lengths of IP and TCP headers to register A
+func (c *bpfCompiler) headersLength() []bpf.Instruction {
+	c.x = []uint32{}
+	return []bpf.Instruction{
+		bpf.LoadMemShift{Off: c.linkOffset},
+		bpf.LoadIndirect{Off: c.linkOffset + 12, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xf0},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 2},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+	}
+}
+
+var jumpTests = map[Op]bpf.JumpTest{
+	OpEqual:          bpf.JumpEqual,
+	OpNotEqual:       bpf.JumpNotEqual,
+	OpLess:           bpf.JumpLessThan,
+	OpLessOrEqual:    bpf.JumpLessOrEqual,
+	OpGreater:        bpf.JumpGreaterThan,
+	OpGreaterOrEqual: bpf.JumpGreaterOrEqual,
+}
+
+func jumpIf(op Op, value uint32) bpf.Instruction {
+	return bpf.JumpIf{Cond: jumpTests[op], Val: value}
+}
+
+func jumpIfX(op Op) bpf.Instruction {
+	return bpf.JumpIfX{Cond: jumpTests[op]}
+}
+
+// setJump sets skips of the last jump of the test
+func setJump(test []bpf.Instruction, skipTrue uint8, skipFalse uint8) []bpf.Instruction {
+
+	switch jump := test[len(test)-1].(type) {
+	case bpf.JumpIf:
+		jump.SkipTrue, jump.SkipFalse = skipTrue, skipFalse
+		test[len(test)-1] = jump
+	case bpf.JumpIfX:
+		jump.SkipTrue, jump.SkipFalse = skipTrue, skipFalse
+		test[len(test)-1] = jump
+	}
+
+	return test
+}
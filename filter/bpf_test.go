@@ -0,0 +1,215 @@
+package filter
+
+import (
+	"errors"
+	"github.com/alytsin/go-p0f"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestCompileBPFDefaultDatabase(t *testing.T) {
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+		testFilterOracle(t, defaultSignatures(direction), direction, 5, bpfMatcher)
+	}
+}
+
+func TestCompileBPF(t *testing.T) {
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+		testFilterOracle(t, rareSignatures(t), direction, 50, bpfMatcher)
+	}
+}
+
+// packetMatcher reports whether the filter accepts IPv4 packet
+type packetMatcher func(t *testing.T, data []byte) bool
+
+func bpfMatcher(sig *signature.Signature, direction signature.Direction) (packetMatcher, error) {
+
+	program, err := CompileBPF(sig, direction, LinkOffsetRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := bpf.NewVM(program)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(t *testing.T, data []byte) bool {
+		accepted, err := vm.Run(data)
+		assert.Nil(t, err)
+		return accepted == AcceptLength
+	}, nil
+}
+
+// testFilterOracle checks that filters of signatures accept packets spoofed for every signature
+// if and only if the packet matches the signature of the filter, see p0f.Verify
+func testFilterOracle(t *testing.T, sigs []*signature.Signature, direction signature.Direction, packets int,
+	compile func(sig *signature.Signature, direction signature.Direction) (packetMatcher, error)) {
+
+	rnd := rand.New(rand.NewSource(1))
+	matchers := make([]packetMatcher, len(sigs))
+
+	for i, sig := range sigs {
+		matcher, err := compile(sig, direction)
+		if errors.Is(err, ErrUnsupported) || errors.Is(err, ErrUnsatisfiable) {
+			continue
+		}
+		if assert.Nil(t, err, sig.String()) {
+			matchers[i] = matcher
+		}
+	}
+
+	for j, sig := range sigs {
+		if sig.IpVersion == signature.IpVersion6 || sig.Quirks != nil && sig.Quirks.Bad {
+			continue
+		}
+
+		for n := 0; n < packets; n++ {
+			data, ipv4, tcp := spoofTestPacket(t, rnd, sig, direction)
+
+			// spoofed packets of satisfiable signatures match them
+			if matchers[j] != nil {
+				assert.Empty(t, p0f.Verify(ipv4, tcp, sig), sig.String())
+			}
+
+			for i, other := range sigs {
+				if matchers[i] == nil {
+					continue
+				}
+
+				mismatches := p0f.Verify(ipv4, tcp, other)
+				if !assert.Equal(t, len(mismatches) == 0, matchers[i](t, data), "%s %s %v", other, p0f.Observe(ipv4, tcp), mismatches) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// testSignatures returns signatures with rare options and quirks followed by signatures of the embedded database
+func testSignatures(t *testing.T, direction signature.Direction) []*signature.Signature {
+	return append(rareSignatures(t), defaultSignatures(direction)...)
+}
+
+// rareSignatures returns signatures with rare options and quirks
+func rareSignatures(t *testing.T) []*signature.Signature {
+
+	parser := signature.Parser{}
+	var sigs []*signature.Signature
+
+	for _, raw := range []string{
+		"4:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0",
+		"*:128-:0:1460:%8192,*:mss,nop,nop,sok:ecn:*",
+		"*:64:0:*:mtu*4,7:mss,sack,ts,nop,ws:id-,ack+:+",
+		"*:64:0:*:mss*10,7:mss,tfo,nop,ws,?40,sok,ts:df,ts2+:0",
+		"*:255:0:0:1024,0:nop,nop,eol+1:df,opt+,uptr+,pushf+:0",
+		"*:64:0:*:*,*:mss,nop,ws,eol+3:0+,seq-,urgf+:*",
+		"*:32:0:*:16384,*:mss,nop,ws:exws,ack-:0",
+		"*:64:0:1380:%1024,8:mss,nop,ws:df,id+,ecn:+",
+	} {
+		sig, err := parser.Parse(raw)
+		if assert.Nil(t, err, raw) {
+			sigs = append(sigs, sig)
+		}
+	}
+
+	return sigs
+}
+
+// defaultSignatures returns signatures of the embedded database
+func defaultSignatures(direction signature.Direction) []*signature.Signature {
+
+	var sigs []*signature.Signature
+	for _, record := range signature.DefaultDatabase().Records(direction) {
+		sigs = append(sigs, record.Signature)
+	}
+
+	return sigs
+}
+
+func TestCompileBPFLinkOffset(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0")
+	rnd := rand.New(rand.NewSource(1))
+
+	program, err := CompileBPF(sig, signature.DirectionRequest, LinkOffsetEthernet)
+	assert.Nil(t, err)
+
+	vm, err := bpf.NewVM(program)
+	assert.Nil(t, err)
+
+	data, _, _ := spoofTestPacket(t, rnd, sig, signature.DirectionRequest)
+	frame := append(make([]byte, LinkOffsetEthernet), data...)
+
+	accepted, err := vm.Run(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, AcceptLength, accepted)
+
+	// response is not accepted by filter of request
+	data, _, _ = spoofTestPacket(t, rnd, sig, signature.DirectionResponse)
+	frame = append(make([]byte, LinkOffsetEthernet), data...)
+
+	accepted, err = vm.Run(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, accepted)
+
+	// truncated packets are rejected
+	accepted, err = vm.Run(frame[:LinkOffsetEthernet+30])
+	assert.Nil(t, err)
+	assert.Equal(t, 0, accepted)
+}
+
+// spoofTestPacket returns serialized packet spoofed to match the signature, and its decoded layers
+func spoofTestPacket(t *testing.T, rnd *rand.Rand, sig *signature.Signature, direction signature.Direction) ([]byte, *layers.IPv4, *layers.TCP) {
+
+	ipv4 := &layers.IPv4{
+		Version:  4,
+		TOS:      uint8(rnd.Intn(0x100)),
+		Id:       uint16(rnd.Intn(0x10000)),
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IPv4(192, 168, 0, 1),
+		DstIP:    net.IPv4(192, 168, 0, 2),
+	}
+
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(rnd.Intn(0xFFFF) + 1),
+		DstPort: 443,
+		SYN:     true,
+		ACK:     direction == signature.DirectionResponse,
+		Seq:     rnd.Uint32(),
+		Ack:     rnd.Uint32(),
+		Window:  uint16(rnd.Intn(0x10000)),
+	}
+
+	spoofer, err := p0f.NewSpoofer(
+		p0f.WithSignature(sig),
+		p0f.WithRandSource(rand.NewSource(rnd.Int63())),
+		p0f.WithTTLDistance(rnd.Intn(signature.MaxDistance)),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+
+	if sig.PayloadSize == signature.PayloadSizeNonZero {
+		assert.Nil(t, p0f.SpoofTcpPayload(tcp, sig, []byte("GET / HTTP/1.1\r\n\r\n")))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.Nil(t, tcp.SetNetworkLayerForChecksum(ipv4))
+	assert.Nil(t, gopacket.SerializeLayers(buf, opts, ipv4, p0f.ExactTcpLayer{TCP: tcp}, gopacket.Payload(tcp.Payload)))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	decodedIpv4, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	decodedTcp, _ := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	assert.NotNil(t, decodedIpv4)
+	assert.NotNil(t, decodedTcp)
+
+	return buf.Bytes(), decodedIpv4, decodedTcp
+}
@@ -0,0 +1,216 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"golang.org/x/net/bpf"
+	"strconv"
+	"strings"
+)
+
+// limits of xt_u32 module: tests per match, and locations and ranges per test
+const (
+	u32MaxTests     = 11
+	u32MaxLocations = 11
+)
+
+// maximum number of instructions of xt_bpf module
+const iptablesMaxBPF = 64
+
+// CompileIptables compiles the signature to iptables matches, see Build and Rule.Iptables
+func CompileIptables(sig *signature.Signature, direction signature.Direction) ([]string, error) {
+
+	rule, err := Build(sig, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.Iptables()
+}
+
+// Iptables returns iptables matches of the rule, a packet matches the rule if it matches any of them,
+// so every match is a separate iptables rule with the same target:
+//
+//	iptables -A INPUT -p tcp -m u32 --u32 "0>>24&0xf0=0x40&&..." -m u32 --u32 "..." -j DROP
+//
+// u32 matches are returned if the rule can be expressed with them, otherwise the rule is a single
+// bpf match, see IptablesBPF.
+func (r *Rule) Iptables() ([]string, error) {
+
+	matches, err := r.IptablesU32()
+	if errors.Is(err, ErrUnsupported) {
+		match, err := r.IptablesBPF()
+		if err != nil {
+			return nil, err
+		}
+		return []string{match}, nil
+	}
+
+	return matches, err
+}
+
+// IptablesBPF returns bpf match of the rule with bytecode of classic BPF program, see Rule.BPF.
+// ErrUnsupported is returned if the program is longer than 64 instructions.
+func (r *Rule) IptablesBPF() (string, error) {
+
+	program, err := r.BPF(LinkOffsetRaw)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := bpf.Assemble(program)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) > iptablesMaxBPF {
+		return "", fmt.Errorf("%w: %d BPF instructions", ErrUnsupported, len(raw))
+	}
+
+	// "count,code jt jf k,..." as printed by nfbpf_compile
+	bytecode := make([]string, 0, len(raw)+1)
+	bytecode = append(bytecode, strconv.Itoa(len(raw)))
+	for _, instruction := range raw {
+		bytecode = append(bytecode, fmt.Sprintf("%d %d %d %d", instruction.Op, instruction.Jt, instruction.Jf, instruction.K))
+	}
+
+	return `-m bpf --bytecode "` + strings.Join(bytecode, ",") + `"`, nil
+}
+
+// IptablesU32 returns u32 matches of the rule, tests are split to several matches by limits of u32.
+// ErrUnsupported is returned for window size of "mss*N" and "mtu*N" with MSS wildcard,
+// window size of "%N" if N is not a power of two, options of variable length and payload class
+// with IP options wildcard.
+func (r *Rule) IptablesU32() ([]string, error) {
+
+	rules := r.expand()
+	matches := make([]string, 0, len(rules))
+
+	for _, rule := range rules {
+		tests := make([]string, 0, len(rule.Conditions))
+		for _, condition := range rule.Conditions {
+			test, err := u32Test(condition)
+			if err != nil {
+				return nil, err
+			}
+			tests = append(tests, test)
+		}
+
+		var match []string
+		for len(tests) > 0 {
+			n := min(len(tests), u32MaxTests)
+			match = append(match, `-m u32 --u32 "`+strings.Join(tests[:n], "&&")+`"`)
+			tests = tests[n:]
+		}
+		matches = append(matches, strings.Join(match, " "))
+	}
+
+	return matches, nil
+}
+
+func u32Test(condition Condition) (string, error) {
+
+	switch condition.Kind {
+	case KindValue:
+		location, max, err := u32Location(condition.Field)
+		if err != nil {
+			return "", err
+		}
+		return location + "=" + u32Range(condition.Op, condition.Value, max), nil
+
+	case KindModulo:
+		mask, err := moduloMask(condition.Value)
+		if err != nil {
+			return "", err
+		}
+		field := condition.Field
+		field.Mask = mask
+		location, _, err := u32Location(field)
+		if err != nil {
+			return "", err
+		}
+		return location + "=0", nil
+
+	case KindHeaderLength, KindPayloadLength:
+		return "", fmt.Errorf("%w: variable length of headers", ErrUnsupported)
+
+	case KindField:
+		return "", fmt.Errorf("%w: comparison of fields", ErrUnsupported)
+	}
+
+	return "", fmt.Errorf("%w: condition kind %d", ErrUnsupported, condition.Kind)
+}
+
+// u32Location returns location of the field and its maximum value. u32 reads 4 bytes,
+// so the field is read with preceding bytes to not read past the end of packet.
+func u32Location(field Field) (string, uint32, error) {
+
+	max := uint32(1)<<(field.Size*8) - 1
+	if field.Size == 4 {
+		max = 0xFFFFFFFF
+	}
+
+	var location string
+	if field.Header == HeaderTCP {
+		if len(field.Lengths)+2 > u32MaxLocations {
+			return "", 0, fmt.Errorf("%w: too many options of variable length", ErrUnsupported)
+		}
+
+		// start of TCP header, moved by length bytes
+		location = "0>>22&0x3C@"
+		for _, length := range field.Lengths {
+			location += strconv.Itoa(int(length)-3) + "&0xFF@"
+		}
+	}
+
+	start := int(field.Offset+field.Size) - 4
+	if start >= 0 {
+		location += strconv.Itoa(start)
+	} else {
+		location += "0>>" + strconv.Itoa(-start*8)
+	}
+
+	if field.Mask != 0 {
+		max = field.Mask
+	}
+	if max != 0xFFFFFFFF {
+		location += fmt.Sprintf("&0x%X", max)
+	}
+
+	return location, max, nil
+}
+
+// u32Range returns ranges of values for the comparison, values are from 0 to max
+func u32Range(op Op, value uint32, max uint32) string {
+
+	hex := func(value uint32) string {
+		return fmt.Sprintf("0x%X", value)
+	}
+	span := func(from uint32, to uint32) string {
+		if from == to {
+			return hex(from)
+		}
+		return hex(from) + ":" + hex(to)
+	}
+
+	switch op {
+	case OpNotEqual:
+		switch value {
+		case 0:
+			return span(1, max)
+		case max:
+			return span(0, max-1)
+		}
+		return span(0, value-1) + "," + span(value+1, max)
+	case OpLess:
+		return span(0, value-1)
+	case OpLessOrEqual:
+		return span(0, value)
+	case OpGreater:
+		return span(value+1, max)
+	case OpGreaterOrEqual:
+		return span(value, max)
+	}
+
+	return hex(value)
+}
@@ -0,0 +1,176 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/bpf"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompileIptables(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, err := parser.Parse("*:64:0:1460:mss*20,10:mss,sok,ts,nop,ws:df,id+:0")
+	assert.Nil(t, err)
+
+	matches, err := CompileIptables(sig, signature.DirectionRequest)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`-m u32 --u32 "0>>24&0xF0=0x40&&6&0xFF=0x6&&4&0x1FFF=0x0&&5&0xFF=0x0:0x40&&5&0xFF=0x1D:0xFF&&` +
+		`0>>24&0xF=0x5&&3&0x40=0x1:0x40&&2&0xFFFF=0x1:0xFFFF&&3&0x80=0x0&&0>>16&0x3=0x0&&0>>22&0x3C@10&0xC0=0x0" ` +
+		`-m u32 --u32 "0>>22&0x3C@9&0x1=0x0&&0>>22&0x3C@10&0x17=0x2&&0>>22&0x3C@4=0x1:0xFFFFFFFF&&0>>22&0x3C@8=0x0&&` +
+		`0>>22&0x3C@10&0x20=0x0&&0>>22&0x3C@16&0xFFFF=0x0&&0>>22&0x3C@10&0x8=0x0&&0>>22&0x3C@17&0xFF=0x2&&` +
+		`0>>22&0x3C@18&0xFF=0x4&&0>>22&0x3C@21&0xFF=0x4&&0>>22&0x3C@22&0xFF=0x2" ` +
+		`-m u32 --u32 "0>>22&0x3C@23&0xFF=0x8&&0>>22&0x3C@24&0xFF=0xA&&0>>22&0x3C@33&0xFF=0x1&&0>>22&0x3C@34&0xFF=0x3&&` +
+		`0>>22&0x3C@35&0xFF=0x3&&0>>22&0x3C@9&0xF0=0xA0&&0>>22&0x3C@20&0xFFFF=0x5B4&&0>>22&0x3C@36&0xFF=0xA&&` +
+		`0>>22&0x3C@28=0x1:0xFFFFFFFF&&0>>22&0x3C@32=0x0&&0>>22&0x3C@12&0xFFFF=0x7210" ` +
+		`-m u32 --u32 "0&0xFFFF=0x3C"`}, matches)
+
+	// window size of MSS wildcard is matched by BPF
+	sig, _ = parser.Parse("*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0")
+	matches, err = CompileIptables(sig, signature.DirectionRequest)
+	assert.Nil(t, err)
+	assert.Len(t, matches, 1)
+	assert.True(t, strings.HasPrefix(matches[0], `-m bpf --bytecode "`), matches[0])
+
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+		testFilterOracle(t, testSignatures(t, direction), direction, 5, iptablesMatcher)
+	}
+}
+
+var (
+	iptablesMatch = regexp.MustCompile(`-m (u32 --u32|bpf --bytecode) "([^"]*)"`)
+	u32Operators  = regexp.MustCompile(`&|<<|>>|@`)
+)
+
+func iptablesMatcher(sig *signature.Signature, direction signature.Direction) (packetMatcher, error) {
+
+	rules, err := CompileIptables(sig, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	// every rule is a list of matches
+	var evaluators [][]func(t *testing.T, data []byte) bool
+	for _, rule := range rules {
+		var matches []func(t *testing.T, data []byte) bool
+		for _, match := range iptablesMatch.FindAllStringSubmatch(rule, -1) {
+			if match[1] == "bpf --bytecode" {
+				vm, err := iptablesBPF(match[2])
+				if err != nil {
+					return nil, err
+				}
+				matches = append(matches, func(t *testing.T, data []byte) bool {
+					accepted, err := vm.Run(data)
+					assert.Nil(t, err)
+					return accepted > 0
+				})
+			} else {
+				expression := match[2]
+				matches = append(matches, func(t *testing.T, data []byte) bool {
+					return evalU32(t, expression, data)
+				})
+			}
+		}
+		evaluators = append(evaluators, matches)
+	}
+
+	return func(t *testing.T, data []byte) bool {
+		for _, matches := range evaluators {
+			accepted := true
+			for _, match := range matches {
+				accepted = accepted && match(t, data)
+			}
+			if accepted {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// iptablesBPF returns VM of bytecode in "count,code jt jf k,..." format
+func iptablesBPF(bytecode string) (*bpf.VM, error) {
+
+	instructions := strings.Split(bytecode, ",")
+	count, _ := strconv.Atoi(instructions[0])
+	if count != len(instructions)-1 || count > iptablesMaxBPF {
+		return nil, fmt.Errorf("invalid count of instructions %d", count)
+	}
+
+	raw := make([]bpf.RawInstruction, 0, count)
+	for _, instruction := range instructions[1:] {
+		var r bpf.RawInstruction
+		if _, err := fmt.Sscanf(instruction, "%d %d %d %d", &r.Op, &r.Jt, &r.Jf, &r.K); err != nil {
+			return nil, err
+		}
+		raw = append(raw, r)
+	}
+
+	program, _ := bpf.Disassemble(raw)
+	return bpf.NewVM(program)
+}
+
+// evalU32 evaluates tests of xt_u32, out of range reads do not match
+func evalU32(t *testing.T, expression string, data []byte) bool {
+
+	number := func(s string) uint32 {
+		n, err := strconv.ParseUint(s, 0, 32)
+		assert.Nil(t, err, s)
+		return uint32(n)
+	}
+	for _, test := range strings.Split(expression, "&&") {
+		location, ranges, found := strings.Cut(test, "=")
+		assert.True(t, found, test)
+
+		operands := u32Operators.Split(location, -1)
+		ops := u32Operators.FindAllString(location, -1)
+
+		base := uint32(0)
+		read := func(offset uint32) (uint32, bool) {
+			if int(base+offset)+4 > len(data) {
+				return 0, false
+			}
+			return binary.BigEndian.Uint32(data[base+offset:]), true
+		}
+
+		value, ok := read(number(operands[0]))
+		if !ok {
+			return false
+		}
+		for i, op := range ops {
+			n := number(operands[i+1])
+			switch op {
+			case "&":
+				value &= n
+			case "<<":
+				value <<= n
+			case ">>":
+				value >>= n
+			case "@":
+				base += value
+				if value, ok = read(n); !ok {
+					return false
+				}
+			}
+		}
+
+		matched := false
+		for _, r := range strings.Split(ranges, ",") {
+			from, to, found := strings.Cut(r, ":")
+			if !found {
+				to = from
+			}
+			matched = matched || value >= number(from) && value <= number(to)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
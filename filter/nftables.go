@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CompileNftables compiles the signature to nftables expressions, see Build and Rule.Nftables
+func CompileNftables(sig *signature.Signature, direction signature.Direction) ([]string, error) {
+
+	rule, err := Build(sig, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.Nftables()
+}
+
+// Nftables returns nftables raw payload expressions of the rule, a packet matches the rule
+// if it matches any of them, so every expression is a separate nft rule with the same verdict:
+//
+//	nft add rule inet filter input @nh,0,8 & 0xf0 == 0x40 @nh,72,8 == 6 ... drop
+//
+// nftables has neither indirect offsets nor arithmetic of fields, so ErrUnsupported is returned for options
+// of variable length (sack, tfo, ?n) followed by other options, window size of "mss*N" and "mtu*N"
+// with MSS wildcard, window size of "%N" if N is not a power of two, and payload class with IP options wildcard.
+func (r *Rule) Nftables() ([]string, error) {
+
+	rules := r.expand()
+	expressions := make([]string, 0, len(rules))
+
+	for _, rule := range rules {
+		conditions := make([]string, 0, len(rule.Conditions))
+		for _, condition := range rule.Conditions {
+			s, err := nftablesCondition(condition)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, s)
+		}
+		expressions = append(expressions, strings.Join(conditions, " "))
+	}
+
+	return expressions, nil
+}
+
+func nftablesCondition(condition Condition) (string, error) {
+
+	switch condition.Kind {
+	case KindValue:
+		field, err := nftablesField(condition.Field)
+		if err != nil {
+			return "", err
+		}
+		return field + " " + string(condition.Op) + " " + hexValue(condition.Field, condition.Value), nil
+
+	case KindModulo:
+		field, err := nftablesField(condition.Field)
+		if err != nil {
+			return "", err
+		}
+		mask, err := moduloMask(condition.Value)
+		if err != nil {
+			return "", err
+		}
+		return field + fmt.Sprintf(" & 0x%x == 0", mask), nil
+
+	case KindHeaderLength, KindPayloadLength:
+		return "", fmt.Errorf("%w: variable length of headers", ErrUnsupported)
+
+	case KindField:
+		return "", fmt.Errorf("%w: comparison of fields", ErrUnsupported)
+	}
+
+	return "", fmt.Errorf("%w: condition kind %d", ErrUnsupported, condition.Kind)
+}
+
+// nftablesField returns "@th,offset,length & mask", offset and length are in bits
+func nftablesField(field Field) (string, error) {
+
+	if len(field.Lengths) > 0 {
+		return "", fmt.Errorf("%w: options of variable length", ErrUnsupported)
+	}
+
+	header := "@nh"
+	if field.Header == HeaderTCP {
+		header = "@th"
+	}
+
+	s := fmt.Sprintf("%s,%d,%d", header, field.Offset*8, field.Size*8)
+	if field.Mask != 0 {
+		s += fmt.Sprintf(" & 0x%02x", field.Mask)
+	}
+
+	return s, nil
+}
+
+// moduloMask returns mask of the remainder of division by power of two
+func moduloMask(n uint32) (uint32, error) {
+	if bits.OnesCount32(n) != 1 {
+		return 0, fmt.Errorf("%w: modulo %d is not a power of two", ErrUnsupported, n)
+	}
+	return n - 1, nil
+}
+
+// hexValue returns masked values in hex
+func hexValue(field Field, value uint32) string {
+	if field.Mask != 0 && value != 0 {
+		return fmt.Sprintf("0x%02x", value)
+	}
+	return strconv.Itoa(int(value))
+}
@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompileNftables(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, err := parser.Parse("*:64:0:1460:mss*20,10:mss,sok,ts,nop,ws:df,id+,ecn:0")
+	assert.Nil(t, err)
+
+	expressions, err := CompileNftables(sig, signature.DirectionRequest)
+	assert.Nil(t, err)
+	assert.Len(t, expressions, 3)
+	assert.Equal(t, "@nh,0,8 & 0xf0 == 0x40 @nh,72,8 == 6 @nh,48,16 & 0x1fff == 0 @nh,64,8 <= 64 @nh,64,8 >= 29 "+
+		"@nh,0,8 & 0x0f == 0x05 @nh,48,8 & 0x40 != 0 @nh,32,16 != 0 @nh,48,8 & 0x80 == 0 @nh,8,8 & 0x03 != 0 "+
+		"@th,104,8 & 0x17 == 0x02 @th,32,32 != 0 @th,64,32 == 0 @th,104,8 & 0x20 == 0 @th,144,16 == 0 @th,104,8 & 0x08 == 0 "+
+		"@th,160,8 == 2 @th,168,8 == 4 @th,192,8 == 4 @th,200,8 == 2 @th,208,8 == 8 @th,216,8 == 10 @th,288,8 == 1 "+
+		"@th,296,8 == 3 @th,304,8 == 3 @th,96,8 & 0xf0 == 0xa0 @th,176,16 == 1460 @th,312,8 == 10 @th,224,32 != 0 "+
+		"@th,256,32 == 0 @th,112,16 == 29200 @nh,16,16 == 60", expressions[0])
+	assert.Contains(t, expressions[1], " @th,104,8 & 0xc0 != 0 ")
+	assert.Contains(t, expressions[2], " @th,96,8 & 0x01 != 0 ")
+
+	// window size of MSS wildcard
+	sig, _ = parser.Parse("*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0")
+	_, err = CompileNftables(sig, signature.DirectionRequest)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+		testFilterOracle(t, testSignatures(t, direction), direction, 5, nftablesMatcher)
+	}
+}
+
+func nftablesMatcher(sig *signature.Signature, direction signature.Direction) (packetMatcher, error) {
+
+	expressions, err := CompileNftables(sig, direction)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(t *testing.T, data []byte) bool {
+		for _, expression := range expressions {
+			if evalNftables(t, expression, data) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// evalNftables evaluates "@th,offset,length [& mask] op value ..." for IPv4 packet
+func evalNftables(t *testing.T, expression string, data []byte) bool {
+
+	number := func(s string) uint32 {
+		n, err := strconv.ParseUint(s, 0, 32)
+		assert.Nil(t, err, s)
+		return uint32(n)
+	}
+
+	tokens := strings.Fields(expression)
+	for len(tokens) > 0 {
+		payload := strings.Split(tokens[0], ",")
+		if !assert.Len(t, payload, 3, expression) {
+			return false
+		}
+
+		offset := number(payload[1]) / 8
+		if payload[0] == "@th" {
+			offset += uint32(data[0]&0x0f) * 4
+		}
+		size := number(payload[2]) / 8
+		if int(offset+size) > len(data) {
+			return false
+		}
+
+		var value uint32
+		for _, b := range data[offset : offset+size] {
+			value = value<<8 | uint32(b)
+		}
+
+		tokens = tokens[1:]
+		if tokens[0] == "&" {
+			value &= number(tokens[1])
+			tokens = tokens[2:]
+		}
+
+		if !compare(Op(tokens[0]), value, number(tokens[1])) {
+			return false
+		}
+		tokens = tokens[2:]
+	}
+
+	return true
+}
+
+func compare(op Op, a uint32, b uint32) bool {
+	switch op {
+	case OpEqual:
+		return a == b
+	case OpNotEqual:
+		return a != b
+	case OpLess:
+		return a < b
+	case OpLessOrEqual:
+		return a <= b
+	case OpGreater:
+		return a > b
+	case OpGreaterOrEqual:
+		return a >= b
+	}
+	return false
+}
@@ -0,0 +1,506 @@
+// Package filter compiles p0f signatures to packet filters matching SYN or SYN+ACK packets
+// of IPv4, e.g. classic BPF programs and tcpdump expressions.
+// https://blog.cloudflare.com/introducing-the-p0f-bpf-compiler
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	// ErrUnsupported is returned for signatures which can not be expressed as a filter,
+	// e.g. IPv6 signatures or "bad" quirk
+	ErrUnsupported = errors.New("signature is not supported by filter")
+	// ErrUnsatisfiable is returned for signatures which never match a packet
+	ErrUnsatisfiable = errors.New("signature never matches")
+)
+
+// Header of the field
+type Header int
+
+const (
+	HeaderIP Header = iota
+	HeaderTCP
+)
+
+// Field is a value of IPv4 or TCP header in network byte order
+type Field struct {
+	Header Header
+	// byte offset within the header
+	Offset uint32
+	// 1, 2 or 4 bytes
+	Size uint32
+	// bits of the value, all bits if zero
+	Mask uint32
+	// TCP fields after options of variable length (sack, tfo, ?n) are moved by lengths of these options.
+	// Lengths are offsets of their length bytes, every offset and Offset itself are moved by lengths
+	// of all preceding options of variable length.
+	Lengths []uint32
+}
+
+// Op is a comparison operator
+type Op string
+
+const (
+	OpEqual          Op = "=="
+	OpNotEqual       Op = "!="
+	OpLess           Op = "<"
+	OpLessOrEqual    Op = "<="
+	OpGreater        Op = ">"
+	OpGreaterOrEqual Op = ">="
+)
+
+// ConditionKind defines how the condition is evaluated
+type ConditionKind int
+
+const (
+	// Field Op Value
+	KindValue ConditionKind = iota
+	// Field Op Other * Factor + Value, e.g. window size is MSS multiplied by N
+	KindField
+	// Field % Value == 0
+	KindModulo
+	// TCP header length Op position of the end of options, position is Field.Offset
+	// moved by Field.Lengths
+	KindHeaderLength
+	// payload length Op Value, that is IP total length without IP and TCP headers
+	KindPayloadLength
+	// at least one of Any conditions is true
+	KindAny
+)
+
+// Condition of the packet
+type Condition struct {
+	Kind   ConditionKind
+	Field  Field
+	Op     Op
+	Value  uint32
+	Other  Field
+	Factor uint32
+	Any    []Condition
+	// field of the signature the condition is made of, see signature.Field* constants
+	Source string
+}
+
+// Rule is a set of conditions which are all true for matching packets
+type Rule struct {
+	Conditions []Condition
+}
+
+// IPv4 header fields
+var (
+	fieldVersion     = Field{Header: HeaderIP, Offset: 0, Size: 1, Mask: 0xf0}
+	fieldIHL         = Field{Header: HeaderIP, Offset: 0, Size: 1, Mask: 0x0f}
+	fieldECN         = Field{Header: HeaderIP, Offset: 1, Size: 1, Mask: 0x03}
+	fieldId          = Field{Header: HeaderIP, Offset: 4, Size: 2}
+	fieldDF          = Field{Header: HeaderIP, Offset: 6, Size: 1, Mask: 0x40}
+	fieldEvilBit     = Field{Header: HeaderIP, Offset: 6, Size: 1, Mask: 0x80}
+	fieldFragOffset  = Field{Header: HeaderIP, Offset: 6, Size: 2, Mask: 0x1fff}
+	fieldTotalLength = Field{Header: HeaderIP, Offset: 2, Size: 2}
+	fieldTTL         = Field{Header: HeaderIP, Offset: 8, Size: 1}
+	fieldProtocol    = Field{Header: HeaderIP, Offset: 9, Size: 1}
+)
+
+// TCP header fields
+var (
+	fieldSeq        = Field{Header: HeaderTCP, Offset: 4, Size: 4}
+	fieldAck        = Field{Header: HeaderTCP, Offset: 8, Size: 4}
+	fieldDataOffset = Field{Header: HeaderTCP, Offset: 12, Size: 1, Mask: 0xf0}
+	fieldNS         = Field{Header: HeaderTCP, Offset: 12, Size: 1, Mask: 0x01}
+	fieldFlags      = Field{Header: HeaderTCP, Offset: 13, Size: 1, Mask: tcpFIN | tcpSYN | tcpRST | tcpACK}
+	fieldPSH        = Field{Header: HeaderTCP, Offset: 13, Size: 1, Mask: tcpPSH}
+	fieldURG        = Field{Header: HeaderTCP, Offset: 13, Size: 1, Mask: tcpURG}
+	fieldECECWR     = Field{Header: HeaderTCP, Offset: 13, Size: 1, Mask: tcpECE | tcpCWR}
+	fieldWindow     = Field{Header: HeaderTCP, Offset: 14, Size: 2}
+	fieldUrgent     = Field{Header: HeaderTCP, Offset: 18, Size: 2}
+	tcpOptionsAt    = uint32(20)
+)
+
+// TCP flags of byte 13
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+	tcpURG = 0x20
+	tcpECE = 0x40
+	tcpCWR = 0x80
+)
+
+// sizes of TCP options with fixed length
+var tcpOptionSizes = map[layers.TCPOptionKind]uint32{
+	layers.TCPOptionKindMSS:           4,
+	layers.TCPOptionKindWindowScale:   3,
+	layers.TCPOptionKindSACKPermitted: 2,
+	layers.TCPOptionKindTimestamps:    10,
+}
+
+// Build returns conditions of IPv4 packets matching the signature, semantics are the same
+// as of signature.Compare with the observed signature of the packet. Direction defines
+// TCP flags: SYN for requests and SYN+ACK for responses.
+func Build(sig *signature.Signature, direction signature.Direction) (*Rule, error) {
+
+	if sig.IpVersion == signature.IpVersion6 {
+		return nil, fmt.Errorf("%w: IPv6", ErrUnsupported)
+	}
+
+	quirks := sig.Quirks
+	if quirks == nil {
+		quirks = &signature.QuirkFlags{}
+	}
+	if quirks.Bad {
+		return nil, fmt.Errorf("%w: malformed options", ErrUnsupported)
+	}
+
+	// quirks which are never reported together, see p0f.Observe
+	switch {
+	case quirks.IdPlus && !quirks.DF, quirks.IdMinus && quirks.DF:
+		return nil, fmt.Errorf("%w: IP identification quirks do not agree with df", ErrUnsatisfiable)
+	case quirks.UptrPlus && quirks.UrgfPlus:
+		return nil, fmt.Errorf("%w: uptr+ with urgf+", ErrUnsatisfiable)
+	case quirks.AckPlus && (quirks.AckMinus || direction == signature.DirectionResponse):
+		return nil, fmt.Errorf("%w: ack+ with ACK flag", ErrUnsatisfiable)
+	}
+
+	b := &builder{}
+
+	// IPv4 with TCP, first fragment
+	b.value(signature.FieldVersion, fieldVersion, OpEqual, 0x40)
+	b.value(signature.FieldVersion, fieldProtocol, OpEqual, uint32(layers.IPProtocolTCP))
+	b.value(signature.FieldVersion, fieldFragOffset, OpEqual, 0)
+
+	b.buildTTL(sig)
+
+	if sig.OptionLength != signature.OptionLengthWildcardIntValue {
+		if sig.OptionLength%4 != 0 {
+			return nil, fmt.Errorf("%w: IP options length %d", ErrUnsatisfiable, sig.OptionLength)
+		}
+		b.value(signature.FieldOptionsLen, fieldIHL, OpEqual, uint32(5+sig.OptionLength/4))
+	}
+
+	b.buildIpQuirks(quirks)
+	b.buildTcpFlags(quirks, direction)
+
+	if err := b.buildOptions(sig, quirks, direction); err != nil {
+		return nil, err
+	}
+
+	if err := b.buildWindow(sig); err != nil {
+		return nil, err
+	}
+
+	if sig.PayloadSize != signature.PayloadSizeAny {
+		op := opIf(sig.PayloadSize == signature.PayloadSizeZero, OpEqual, OpGreater)
+
+		// payload is the rest of IP total length if lengths of headers are known
+		if sig.OptionLength != signature.OptionLengthWildcardIntValue && b.tcpLength > 0 {
+			b.value(signature.FieldPayloadSize, fieldTotalLength, op, uint32(20+sig.OptionLength)+b.tcpLength)
+		} else {
+			b.add(Condition{Kind: KindPayloadLength, Op: op, Value: 0, Source: signature.FieldPayloadSize})
+		}
+	}
+
+	return &b.rule, nil
+}
+
+type builder struct {
+	rule Rule
+	// fields of options found in the layout
+	mss, ws, ts1, ts2 *Field
+	// TCP header length, zero if it depends on options of variable length
+	tcpLength uint32
+}
+
+func (b *builder) add(condition Condition) {
+	b.rule.Conditions = append(b.rule.Conditions, condition)
+}
+
+func (b *builder) value(source string, field Field, op Op, value uint32) {
+	b.add(Condition{Kind: KindValue, Field: field, Op: op, Value: value, Source: source})
+}
+
+func (b *builder) buildTTL(sig *signature.Signature) {
+
+	ttl := uint32(min(sig.InitialTTL, 0xFF))
+	b.value(signature.FieldInitialTTL, fieldTTL, OpLessOrEqual, ttl)
+
+	// userspace tools with random TTLs are limited only by maximum
+	if lowest := sig.InitialTTL - signature.MaxDistance; !sig.RandomTTL && lowest > 0 {
+		b.value(signature.FieldInitialTTL, fieldTTL, OpGreaterOrEqual, uint32(lowest))
+	}
+}
+
+func (b *builder) buildIpQuirks(quirks *signature.QuirkFlags) {
+
+	// df, id+, id-
+	if quirks.DF {
+		b.value(signature.FieldQuirks, fieldDF, OpNotEqual, 0)
+		b.value(signature.FieldQuirks, fieldId, opIf(quirks.IdPlus, OpNotEqual, OpEqual), 0)
+	} else {
+		b.value(signature.FieldQuirks, fieldDF, OpEqual, 0)
+		b.value(signature.FieldQuirks, fieldId, opIf(quirks.IdMinus, OpEqual, OpNotEqual), 0)
+	}
+
+	// 0+
+	b.value(signature.FieldQuirks, fieldEvilBit, opIf(quirks.ZeroPlus, OpNotEqual, OpEqual), 0)
+
+	// ecn: ECN bits of IP header or ECE, CWR, NS flags of TCP header
+	ecn := []Condition{
+		{Kind: KindValue, Field: fieldECN, Op: OpNotEqual, Value: 0, Source: signature.FieldQuirks},
+		{Kind: KindValue, Field: fieldECECWR, Op: OpNotEqual, Value: 0, Source: signature.FieldQuirks},
+		{Kind: KindValue, Field: fieldNS, Op: OpNotEqual, Value: 0, Source: signature.FieldQuirks},
+	}
+	if quirks.ECN {
+		b.add(Condition{Kind: KindAny, Any: ecn, Source: signature.FieldQuirks})
+	} else {
+		for _, condition := range ecn {
+			condition.Op = OpEqual
+			b.add(condition)
+		}
+	}
+}
+
+func (b *builder) buildTcpFlags(quirks *signature.QuirkFlags, direction signature.Direction) {
+
+	// SYN of requests has ACK flag only with "ack-" quirk
+	flags := uint32(tcpSYN)
+	if direction == signature.DirectionResponse || quirks.AckMinus {
+		flags |= tcpACK
+	}
+	b.value(signature.FieldQuirks, fieldFlags, OpEqual, flags)
+
+	// seq-
+	b.value(signature.FieldQuirks, fieldSeq, opIf(quirks.SeqMinus, OpEqual, OpNotEqual), 0)
+
+	// ack+, ack-: ACK number is zero if ACK flag is not set, and non-zero otherwise
+	if flags&tcpACK != 0 {
+		b.value(signature.FieldQuirks, fieldAck, opIf(quirks.AckMinus, OpEqual, OpNotEqual), 0)
+	} else {
+		b.value(signature.FieldQuirks, fieldAck, opIf(quirks.AckPlus, OpNotEqual, OpEqual), 0)
+	}
+
+	// urgf+, uptr+
+	b.value(signature.FieldQuirks, fieldURG, opIf(quirks.UrgfPlus, OpNotEqual, OpEqual), 0)
+	if !quirks.UrgfPlus {
+		b.value(signature.FieldQuirks, fieldUrgent, opIf(quirks.UptrPlus, OpNotEqual, OpEqual), 0)
+	}
+
+	// pushf+
+	b.value(signature.FieldQuirks, fieldPSH, opIf(quirks.PushfPlus, OpNotEqual, OpEqual), 0)
+}
+
+func (b *builder) buildOptions(sig *signature.Signature, quirks *signature.QuirkFlags, direction signature.Direction) error {
+
+	offset := tcpOptionsAt
+	var lengths []uint32
+
+	tcpField := func(offset uint32, size uint32) Field {
+		return Field{Header: HeaderTCP, Offset: offset, Size: size, Lengths: lengths}
+	}
+
+	eol := false
+	for i, option := range sig.OptionsLayout {
+
+		b.value(signature.FieldOptions, tcpField(offset, 1), OpEqual, uint32(option))
+
+		if option == layers.TCPOptionKindEndList {
+			// eol+n: n bytes of padding till the end of header, opt+ is non-zero padding
+			padding := make([]Condition, 0, len(sig.OptionsLayout)-i-1)
+			for n := uint32(1); n < uint32(len(sig.OptionsLayout)-i); n++ {
+				padding = append(padding, Condition{Kind: KindValue, Field: tcpField(offset+n, 1), Op: OpNotEqual, Source: signature.FieldQuirks})
+			}
+			offset += uint32(len(sig.OptionsLayout) - i)
+
+			if quirks.OptPlus && len(padding) == 0 {
+				return fmt.Errorf("%w: opt+ without padding", ErrUnsatisfiable)
+			} else if quirks.OptPlus {
+				b.add(Condition{Kind: KindAny, Any: padding, Source: signature.FieldQuirks})
+			} else {
+				for _, condition := range padding {
+					condition.Op = OpEqual
+					b.add(condition)
+				}
+			}
+
+			eol = true
+			break
+		}
+
+		if option == layers.TCPOptionKindNop {
+			offset++
+			continue
+		}
+
+		size, fixed := tcpOptionSizes[option]
+		if !fixed {
+			// option of variable length, following options are moved by its length
+			lengths = append(lengths[:len(lengths):len(lengths)], offset+1)
+			continue
+		}
+
+		// options of fixed size have exact length, p0f reports "bad" quirk otherwise
+		b.value(signature.FieldOptions, tcpField(offset+1, 1), OpEqual, size)
+
+		switch option {
+		case layers.TCPOptionKindMSS:
+			field := tcpField(offset+2, 2)
+			b.mss = &field
+		case layers.TCPOptionKindWindowScale:
+			field := tcpField(offset+2, 1)
+			b.ws = &field
+		case layers.TCPOptionKindTimestamps:
+			ts1 := tcpField(offset+2, 4)
+			ts2 := tcpField(offset+6, 4)
+			b.ts1, b.ts2 = &ts1, &ts2
+		}
+
+		offset += size
+	}
+
+	// header length is a multiple of 4, it is known only at runtime after options of variable length
+	if len(lengths) == 0 && offset%4 != 0 {
+		return fmt.Errorf("%w: options are not aligned", ErrUnsatisfiable)
+	}
+	if quirks.OptPlus && !eol {
+		return fmt.Errorf("%w: opt+ without padding", ErrUnsatisfiable)
+	}
+
+	// header ends right after options layout, data offset is in 32 bit words
+	if len(lengths) == 0 {
+		b.tcpLength = offset
+		b.value(signature.FieldOptions, fieldDataOffset, OpEqual, offset/4<<4)
+	} else {
+		b.add(Condition{Kind: KindHeaderLength, Field: tcpField(offset, 0), Op: OpEqual, Source: signature.FieldOptions})
+	}
+
+	// MSS, options are not set without option
+	if sig.MaximumSegmentSize != signature.MaximumSegmentSizeWildcardIntValue {
+		if b.mss != nil {
+			b.value(signature.FieldMSS, *b.mss, OpEqual, uint32(sig.MaximumSegmentSize))
+		} else if sig.MaximumSegmentSize != 0 {
+			return fmt.Errorf("%w: MSS without MSS option", ErrUnsatisfiable)
+		}
+	}
+
+	// window scale and exws
+	if scale := sig.WindowSize.WindowScalingFactor; scale != signature.WindowScaleFactorWildcardIntValue {
+		if b.ws != nil {
+			b.value(signature.FieldWindowScale, *b.ws, OpEqual, uint32(scale))
+		} else if scale != 0 {
+			return fmt.Errorf("%w: window scale without window scale option", ErrUnsatisfiable)
+		}
+	}
+	switch scale := sig.WindowSize.WindowScalingFactor; {
+	case b.ws == nil && quirks.EXWS:
+		return fmt.Errorf("%w: exws without window scale option", ErrUnsatisfiable)
+	case b.ws != nil && scale == signature.WindowScaleFactorWildcardIntValue:
+		b.value(signature.FieldQuirks, *b.ws, opIf(quirks.EXWS, OpGreater, OpLessOrEqual), 14)
+	case b.ws != nil && (scale > 14) != quirks.EXWS:
+		return fmt.Errorf("%w: window scale %d does not agree with exws", ErrUnsatisfiable, scale)
+	}
+
+	// ts1-, ts2+ is checked only for SYN without ACK
+	if b.ts1 != nil {
+		b.value(signature.FieldQuirks, *b.ts1, opIf(quirks.TsMinus, OpEqual, OpNotEqual), 0)
+		if direction == signature.DirectionRequest && !quirks.AckMinus {
+			b.value(signature.FieldQuirks, *b.ts2, opIf(quirks.TsPlus, OpNotEqual, OpEqual), 0)
+		} else if quirks.TsPlus {
+			return fmt.Errorf("%w: ts2+ with ACK flag", ErrUnsatisfiable)
+		}
+	} else if quirks.TsMinus || quirks.TsPlus {
+		return fmt.Errorf("%w: timestamps quirks without timestamps option", ErrUnsatisfiable)
+	}
+
+	return nil
+}
+
+func (b *builder) buildWindow(sig *signature.Signature) error {
+
+	window := sig.WindowSize
+	source := signature.FieldWindowSize
+
+	switch window.WindowSizeType {
+	case signature.WindowTypeNormal:
+		b.value(source, fieldWindow, OpEqual, uint32(window.WindowSize))
+	case signature.WindowTypeMod:
+		b.add(Condition{Kind: KindModulo, Field: fieldWindow, Value: uint32(window.WindowSize), Source: source})
+	case signature.WindowTypeMSS, signature.WindowTypeMTU:
+		// window size is constant if MSS is known, MSS is zero without option
+		if mss := sig.MaximumSegmentSize; b.mss == nil || mss != signature.MaximumSegmentSizeWildcardIntValue {
+			if b.mss == nil {
+				mss = 0
+			}
+			if window.WindowSizeType == signature.WindowTypeMTU {
+				mss = signature.MTU(mss, signature.IpVersion4)
+			}
+
+			size := mss * int(window.WindowSize)
+			if size > 0xFFFF {
+				return fmt.Errorf("%w: window size %d", ErrUnsatisfiable, size)
+			}
+			b.value(source, fieldWindow, OpEqual, uint32(size))
+			return nil
+		}
+
+		condition := Condition{Kind: KindField, Field: fieldWindow, Op: OpEqual, Other: *b.mss, Factor: uint32(window.WindowSize), Source: source}
+		if window.WindowSizeType == signature.WindowTypeMTU {
+			// (MSS + 40) * N
+			condition.Value = uint32(signature.MTU(0, signature.IpVersion4)) * uint32(window.WindowSize)
+		}
+		b.add(condition)
+	}
+
+	return nil
+}
+
+func opIf(condition bool, then Op, otherwise Op) Op {
+	if condition {
+		return then
+	}
+	return otherwise
+}
+
+// expand returns rules without KindAny conditions, a packet matches the rule if it matches any of them.
+// Used by filters without "or" across different fields.
+func (r *Rule) expand() []*Rule {
+
+	rules := []*Rule{{}}
+	for _, condition := range r.Conditions {
+
+		if condition.Kind != KindAny {
+			for _, rule := range rules {
+				rule.Conditions = append(rule.Conditions, condition)
+			}
+			continue
+		}
+
+		expanded := make([]*Rule, 0, len(rules)*len(condition.Any))
+		for _, rule := range rules {
+			for _, any := range condition.Any {
+				conditions := append(rule.Conditions[:len(rule.Conditions):len(rule.Conditions)], any)
+				expanded = append(expanded, &Rule{Conditions: conditions})
+			}
+		}
+		rules = expanded
+	}
+
+	return rules
+}
+
+// fixedValue returns value of the field if it is set by a condition
+func (r *Rule) fixedValue(field Field) (uint32, bool) {
+
+	for _, condition := range r.Conditions {
+		f := condition.Field
+		if condition.Kind == KindValue && condition.Op == OpEqual && len(f.Lengths) == 0 && len(field.Lengths) == 0 &&
+			f.Header == field.Header && f.Offset == field.Offset && f.Size == field.Size && f.Mask == field.Mask {
+			return condition.Value, true
+		}
+	}
+
+	return 0, false
+}
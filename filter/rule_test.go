@@ -0,0 +1,53 @@
+package filter
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	var testData = []struct {
+		sig       string
+		direction signature.Direction
+		err       error
+	}{
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0", signature.DirectionRequest, nil},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0", signature.DirectionResponse, nil},
+		{"6:64:0:*:mss*20,10:mss,sok,ts,nop,ws::0", signature.DirectionRequest, ErrUnsupported},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:bad:0", signature.DirectionRequest, ErrUnsupported},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:id+:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id-:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,ack+:0", signature.DirectionResponse, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,ts2+:0", signature.DirectionRequest, nil},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,ts2+:0", signature.DirectionResponse, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sok,nop,ws:df,ts1-:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sok,ts,ws:df:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,10:mss,sack,ts,ws:df:0", signature.DirectionRequest, nil},
+		{"*:64:0:*:mss*20,10:mss,nop,nop:df:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:1460:1024,0:nop,nop,nop,nop:df:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:0:mss*20,0:nop,nop,nop,nop:df:0", signature.DirectionRequest, nil},
+		{"*:64:0:*:mss*20,0:mss:df,opt+:0", signature.DirectionRequest, ErrUnsatisfiable},
+		{"*:64:0:*:mss*20,0:mss,eol+3:df,opt+:0", signature.DirectionRequest, nil},
+		{"*:64:2:*:mss*20,0:mss:df:0", signature.DirectionRequest, ErrUnsatisfiable},
+	}
+
+	for _, item := range testData {
+		sig, err := parser.Parse(item.sig)
+		if !assert.Nil(t, err, item.sig) {
+			continue
+		}
+
+		rule, err := Build(sig, item.direction)
+		if item.err == nil {
+			assert.Nil(t, err, item.sig)
+			assert.NotEmpty(t, rule.Conditions, item.sig)
+		} else {
+			assert.ErrorIs(t, err, item.err, item.sig)
+			assert.Nil(t, rule, item.sig)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package filter
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"sort"
+)
+
+// TableEntrySize is the size of binary TableEntry, it is the value size of BPF map:
+//
+//	struct p0f_entry {
+//		__u32 layout_hash;
+//		__u32 quirks;
+//		__u32 record;
+//		__u16 mss;
+//		__u16 window;
+//		__u8  min_ttl;
+//		__u8  max_ttl;
+//		__u8  olen;
+//		__u8  scale;
+//		__u8  window_type;
+//		__u8  version;
+//		__u8  pclass;
+//		__u8  flags;
+//	};
+const TableEntrySize = 24
+
+// bits of TableEntry.Quirks and TableKey.Quirks in the order of signature.QuirkFlags
+const (
+	QuirkBitDF uint32 = 1 << iota
+	QuirkBitIdPlus
+	QuirkBitIdMinus
+	QuirkBitECN
+	QuirkBitZeroPlus
+	QuirkBitFlow
+	QuirkBitSeqMinus
+	QuirkBitAckPlus
+	QuirkBitAckMinus
+	QuirkBitUptrPlus
+	QuirkBitUrgfPlus
+	QuirkBitPushfPlus
+	QuirkBitTsMinus
+	QuirkBitTsPlus
+	QuirkBitOptPlus
+	QuirkBitEXWS
+	QuirkBitBad
+)
+
+// quirks ignored for IP versions, see signature.Compare
+const (
+	quirkBitsIpv4Only = QuirkBitDF | QuirkBitIdPlus | QuirkBitIdMinus | QuirkBitZeroPlus
+	quirkBitsIpv6Only = QuirkBitFlow
+)
+
+// values of TableEntry.Version and TableKey.Version
+const (
+	TableVersionAny uint8 = 0
+	TableVersion4   uint8 = 4
+	TableVersion6   uint8 = 6
+)
+
+// values of TableEntry.PayloadClass and TableKey.PayloadClass
+const (
+	TablePayloadZero    uint8 = 0
+	TablePayloadNonZero uint8 = 1
+	TablePayloadAny     uint8 = 2
+)
+
+// bits of TableEntry.Flags
+const (
+	TableFlagMSSAny uint8 = 1 << iota
+	TableFlagOptionLengthAny
+	TableFlagScaleAny
+)
+
+// TableEntry is a signature flattened to fixed-size values
+type TableEntry struct {
+	// FNV-1a hash of option kinds, see LayoutHash
+	LayoutHash uint32
+	Quirks     uint32
+	// index of the record of the table
+	Record uint32
+	MSS    uint16
+	// window size, multiplier or modulo of WindowType
+	Window uint16
+	// TTL of the packet is in MinTTL..MaxTTL range, that is initial TTL and the maximum distance
+	MinTTL       uint8
+	MaxTTL       uint8
+	OptionLength uint8
+	Scale        uint8
+	// signature.WindowType
+	WindowType   uint8
+	Version      uint8
+	PayloadClass uint8
+	Flags        uint8
+}
+
+// TableKey is a packet flattened to fixed-size values, see NewTableKey
+type TableKey struct {
+	LayoutHash   uint32
+	Quirks       uint32
+	MSS          uint16
+	Window       uint16
+	TTL          uint8
+	OptionLength uint8
+	Scale        uint8
+	Version      uint8
+	PayloadClass uint8
+}
+
+// Table is a database of signatures flattened for BPF maps, e.g. a hash map of layout hash
+// to entries or an array of entries sorted by layout hash. Lookup is the reference implementation
+// of matching for BPF programs.
+type Table struct {
+	Records []*signature.Record
+	// entries sorted by layout hash, entries of the same hash are in order of records
+	Entries []TableEntry
+}
+
+// NewTable flattens records, records which never match a packet (TTL over 255) have no entries
+func NewTable(records []*signature.Record) *Table {
+
+	table := &Table{Records: records, Entries: make([]TableEntry, 0, len(records))}
+
+	for i, record := range records {
+		if entry, ok := newTableEntry(record.Signature); ok {
+			entry.Record = uint32(i)
+			table.Entries = append(table.Entries, entry)
+		}
+	}
+
+	sort.SliceStable(table.Entries, func(i, j int) bool {
+		return table.Entries[i].LayoutHash < table.Entries[j].LayoutHash
+	})
+
+	return table
+}
+
+func newTableEntry(sig *signature.Signature) (TableEntry, bool) {
+
+	entry := TableEntry{
+		LayoutHash:   LayoutHash(sig.OptionsLayout),
+		Quirks:       QuirkBits(sig.Quirks),
+		MaxTTL:       uint8(min(sig.InitialTTL, 0xFF)),
+		PayloadClass: TablePayloadAny,
+	}
+
+	// userspace tools with random TTLs are limited only by maximum
+	if minTTL := sig.InitialTTL - signature.MaxDistance; !sig.RandomTTL && minTTL > 0 {
+		if minTTL > 0xFF {
+			return entry, false
+		}
+		entry.MinTTL = uint8(minTTL)
+	}
+
+	switch sig.IpVersion {
+	case signature.IpVersion4:
+		entry.Version = TableVersion4
+	case signature.IpVersion6:
+		entry.Version = TableVersion6
+	}
+
+	switch sig.PayloadSize {
+	case signature.PayloadSizeZero:
+		entry.PayloadClass = TablePayloadZero
+	case signature.PayloadSizeNonZero:
+		entry.PayloadClass = TablePayloadNonZero
+	}
+
+	if sig.OptionLength == signature.OptionLengthWildcardIntValue {
+		entry.Flags |= TableFlagOptionLengthAny
+	} else {
+		entry.OptionLength = uint8(sig.OptionLength)
+	}
+
+	if sig.MaximumSegmentSize == signature.MaximumSegmentSizeWildcardIntValue {
+		entry.Flags |= TableFlagMSSAny
+	} else {
+		entry.MSS = uint16(sig.MaximumSegmentSize)
+	}
+
+	entry.WindowType = uint8(signature.WindowTypeAny)
+	entry.Flags |= TableFlagScaleAny
+	if window := sig.WindowSize; window != nil {
+		entry.WindowType = uint8(window.WindowSizeType)
+		entry.Window = window.WindowSize
+		if window.WindowScalingFactor != signature.WindowScaleFactorWildcardIntValue {
+			entry.Flags &^= TableFlagScaleAny
+			entry.Scale = uint8(window.WindowScalingFactor)
+		}
+	}
+
+	return entry, true
+}
+
+// NewTableKey flattens the observed signature of a packet, see p0f.Observe
+func NewTableKey(observed *signature.Signature) TableKey {
+
+	key := TableKey{
+		LayoutHash:   LayoutHash(observed.OptionsLayout),
+		Quirks:       QuirkBits(observed.Quirks),
+		MSS:          uint16(observed.MaximumSegmentSize),
+		TTL:          uint8(observed.InitialTTL),
+		OptionLength: uint8(observed.OptionLength),
+		Version:      TableVersion4,
+		PayloadClass: TablePayloadZero,
+	}
+
+	if observed.IpVersion == signature.IpVersion6 {
+		key.Version = TableVersion6
+	}
+	if observed.PayloadSize == signature.PayloadSizeNonZero {
+		key.PayloadClass = TablePayloadNonZero
+	}
+	if observed.WindowSize != nil {
+		key.Window = observed.WindowSize.WindowSize
+		key.Scale = uint8(observed.WindowSize.WindowScalingFactor)
+	}
+
+	return key
+}
+
+// Lookup returns records matching the packet in order of the table, semantics are the same
+// as of signature.Compare except collisions of layout hashes
+func (t *Table) Lookup(key TableKey) []*signature.Record {
+
+	var result []*signature.Record
+
+	i := sort.Search(len(t.Entries), func(i int) bool {
+		return t.Entries[i].LayoutHash >= key.LayoutHash
+	})
+
+	for ; i < len(t.Entries) && t.Entries[i].LayoutHash == key.LayoutHash; i++ {
+		if t.Entries[i].Matches(key) {
+			result = append(result, t.Records[t.Entries[i].Record])
+		}
+	}
+
+	return result
+}
+
+// Matches reports whether the packet matches the entry, layout hash is not compared
+func (e *TableEntry) Matches(key TableKey) bool {
+
+	if e.Version != TableVersionAny && e.Version != key.Version {
+		return false
+	}
+
+	if key.TTL < e.MinTTL || key.TTL > e.MaxTTL {
+		return false
+	}
+
+	if e.Flags&TableFlagOptionLengthAny == 0 && e.OptionLength != key.OptionLength {
+		return false
+	}
+
+	if e.Flags&TableFlagMSSAny == 0 && e.MSS != key.MSS {
+		return false
+	}
+
+	if !e.matchWindow(key) {
+		return false
+	}
+
+	if e.Flags&TableFlagScaleAny == 0 && e.Scale != key.Scale {
+		return false
+	}
+
+	// quirks of another IP version are ignored
+	ignored := quirkBitsIpv6Only
+	if key.Version == TableVersion6 {
+		ignored = quirkBitsIpv4Only
+	}
+	if e.Quirks&^ignored != key.Quirks&^ignored {
+		return false
+	}
+
+	return e.PayloadClass == TablePayloadAny || e.PayloadClass == key.PayloadClass
+}
+
+func (e *TableEntry) matchWindow(key TableKey) bool {
+
+	window := uint32(key.Window)
+
+	switch signature.WindowType(e.WindowType) {
+	case signature.WindowTypeNormal:
+		return key.Window == e.Window
+	case signature.WindowTypeMod:
+		return e.Window != 0 && window%uint32(e.Window) == 0
+	case signature.WindowTypeMSS:
+		return window == uint32(key.MSS)*uint32(e.Window)
+	case signature.WindowTypeMTU:
+		mtu := uint32(key.MSS) + 40
+		if key.Version == TableVersion6 {
+			mtu = uint32(key.MSS) + 60
+		}
+		return window == mtu*uint32(e.Window)
+	}
+
+	return true
+}
+
+// MarshalBinary returns little-endian TableEntrySize bytes
+func (e *TableEntry) MarshalBinary() ([]byte, error) {
+
+	data := make([]byte, TableEntrySize)
+	binary.LittleEndian.PutUint32(data[0:], e.LayoutHash)
+	binary.LittleEndian.PutUint32(data[4:], e.Quirks)
+	binary.LittleEndian.PutUint32(data[8:], e.Record)
+	binary.LittleEndian.PutUint16(data[12:], e.MSS)
+	binary.LittleEndian.PutUint16(data[14:], e.Window)
+	data[16] = e.MinTTL
+	data[17] = e.MaxTTL
+	data[18] = e.OptionLength
+	data[19] = e.Scale
+	data[20] = e.WindowType
+	data[21] = e.Version
+	data[22] = e.PayloadClass
+	data[23] = e.Flags
+
+	return data, nil
+}
+
+// UnmarshalBinary reads little-endian TableEntrySize bytes
+func (e *TableEntry) UnmarshalBinary(data []byte) error {
+
+	if len(data) != TableEntrySize {
+		return errors.New("invalid size of table entry")
+	}
+
+	e.LayoutHash = binary.LittleEndian.Uint32(data[0:])
+	e.Quirks = binary.LittleEndian.Uint32(data[4:])
+	e.Record = binary.LittleEndian.Uint32(data[8:])
+	e.MSS = binary.LittleEndian.Uint16(data[12:])
+	e.Window = binary.LittleEndian.Uint16(data[14:])
+	e.MinTTL = data[16]
+	e.MaxTTL = data[17]
+	e.OptionLength = data[18]
+	e.Scale = data[19]
+	e.WindowType = data[20]
+	e.Version = data[21]
+	e.PayloadClass = data[22]
+	e.Flags = data[23]
+
+	return nil
+}
+
+// LayoutHash returns FNV-1a hash of option kinds, "eol+n" is EOL followed by n more EOL kinds
+func LayoutHash(layout []layers.TCPOptionKind) uint32 {
+
+	hash := uint32(2166136261)
+	for _, kind := range layout {
+		hash ^= uint32(kind)
+		hash *= 16777619
+	}
+
+	return hash
+}
+
+// QuirkBits returns quirks as bits of QuirkBit* constants
+func QuirkBits(quirks *signature.QuirkFlags) uint32 {
+
+	if quirks == nil {
+		return 0
+	}
+
+	var bits uint32
+	for bit, set := range []bool{
+		quirks.DF, quirks.IdPlus, quirks.IdMinus, quirks.ECN, quirks.ZeroPlus, quirks.Flow,
+		quirks.SeqMinus, quirks.AckPlus, quirks.AckMinus, quirks.UptrPlus, quirks.UrgfPlus, quirks.PushfPlus,
+		quirks.TsMinus, quirks.TsPlus, quirks.OptPlus, quirks.EXWS, quirks.Bad,
+	} {
+		if set {
+			bits |= 1 << bit
+		}
+	}
+
+	return bits
+}
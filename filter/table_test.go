@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"github.com/alytsin/go-p0f"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestTableLookup(t *testing.T) {
+
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+
+		sigs := testSignatures(t, direction)
+		records := make([]*signature.Record, len(sigs))
+		for i, sig := range sigs {
+			records[i] = &signature.Record{Signature: sig}
+		}
+
+		table := NewTable(records)
+		assert.Len(t, table.Entries, len(records))
+		rnd := rand.New(rand.NewSource(1))
+
+		for _, sig := range sigs {
+			if sig.IpVersion == signature.IpVersion6 || sig.Quirks != nil && sig.Quirks.Bad {
+				continue
+			}
+
+			for n := 0; n < 3; n++ {
+				_, ipv4, tcp := spoofTestPacket(t, rnd, sig, direction)
+				observed := p0f.Observe(ipv4, tcp)
+
+				var expected []*signature.Record
+				for _, record := range records {
+					if len(record.Signature.Compare(observed)) == 0 {
+						expected = append(expected, record)
+					}
+				}
+
+				assert.ElementsMatch(t, expected, table.Lookup(NewTableKey(observed)), "%s %s", sig, observed)
+			}
+		}
+	}
+}
+
+func TestTableLookupIpVersion(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:1460:mtu*4,7:mss,nop,ws:df,id+:0")
+	table := NewTable([]*signature.Record{{Signature: sig}})
+
+	observed, _ := parser.Parse("4:60:0:1460:6000,7:mss,nop,ws:df,id+:0")
+	assert.Len(t, table.Lookup(NewTableKey(observed)), 1)
+
+	// flow is ignored for IPv4
+	observed.Quirks.Flow = true
+	assert.Len(t, table.Lookup(NewTableKey(observed)), 1)
+
+	// df and id+ are ignored for IPv6, MTU of IPv6 is larger
+	observed, _ = parser.Parse("6:60:0:1460:6080,7:mss,nop,ws:flow:0")
+	assert.Empty(t, table.Lookup(NewTableKey(observed)))
+	observed.Quirks.Flow = false
+	assert.Len(t, table.Lookup(NewTableKey(observed)), 1)
+
+	// TTL is out of range
+	observed.InitialTTL = 28
+	assert.Empty(t, table.Lookup(NewTableKey(observed)))
+}
+
+func TestTableEntryBinary(t *testing.T) {
+
+	entry := TableEntry{
+		LayoutHash:   LayoutHash([]layers.TCPOptionKind{layers.TCPOptionKindMSS, layers.TCPOptionKindNop}),
+		Quirks:       QuirkBitDF | QuirkBitBad,
+		Record:       0x01020304,
+		MSS:          1460,
+		Window:       0x1234,
+		MinTTL:       29,
+		MaxTTL:       64,
+		OptionLength: 4,
+		Scale:        7,
+		WindowType:   uint8(signature.WindowTypeMTU),
+		Version:      TableVersion4,
+		PayloadClass: TablePayloadAny,
+		Flags:        TableFlagScaleAny,
+	}
+
+	data, err := entry.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Len(t, data, TableEntrySize)
+	assert.Equal(t, []byte{0x04, 0x03, 0x02, 0x01}, data[8:12])
+
+	var decoded TableEntry
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, entry, decoded)
+
+	assert.NotNil(t, decoded.UnmarshalBinary(data[1:]))
+}
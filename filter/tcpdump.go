@@ -0,0 +1,106 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"strconv"
+	"strings"
+)
+
+// CompileTcpdump compiles the signature to tcpdump (pcap-filter) expression, see Build and Rule.Tcpdump
+func CompileTcpdump(sig *signature.Signature, direction signature.Direction) (string, error) {
+
+	rule, err := Build(sig, direction)
+	if err != nil {
+		return "", err
+	}
+
+	return rule.Tcpdump(), nil
+}
+
+// Tcpdump returns pcap-filter expression of the rule, e.g.
+//
+//	ip[0] & 0xf0 = 0x40 and ip[8] <= 64 and tcp[24+tcp[21]] = 8 and ...
+//
+// Offsets after options of variable length are expressions of their length bytes.
+func (r *Rule) Tcpdump() string {
+
+	conditions := make([]string, 0, len(r.Conditions))
+	for _, condition := range r.Conditions {
+		conditions = append(conditions, tcpdumpCondition(condition))
+	}
+
+	return strings.Join(conditions, " and ")
+}
+
+func tcpdumpCondition(condition Condition) string {
+
+	switch condition.Kind {
+	case KindValue:
+		return tcpdumpField(condition.Field) + " " + tcpdumpOp(condition.Op) + " " + hexValue(condition.Field, condition.Value)
+
+	case KindModulo:
+		return tcpdumpField(condition.Field) + " % " + strconv.Itoa(int(condition.Value)) + " = 0"
+
+	case KindField:
+		other := tcpdumpField(condition.Other) + " * " + strconv.Itoa(int(condition.Factor))
+		if condition.Value != 0 {
+			other += " + " + strconv.Itoa(int(condition.Value))
+		}
+		return tcpdumpField(condition.Field) + " " + tcpdumpOp(condition.Op) + " " + other
+
+	case KindHeaderLength:
+		return "(tcp[12] & 0xf0) >> 2 " + tcpdumpOp(condition.Op) + " " + tcpdumpOffset(condition.Field.Offset, condition.Field.Lengths)
+
+	case KindPayloadLength:
+		return "ip[2:2] - ((ip[0] & 0x0f) << 2) - ((tcp[12] & 0xf0) >> 2) " + tcpdumpOp(condition.Op) + " " + strconv.Itoa(int(condition.Value))
+
+	case KindAny:
+		any := make([]string, 0, len(condition.Any))
+		for _, c := range condition.Any {
+			any = append(any, tcpdumpCondition(c))
+		}
+		return "(" + strings.Join(any, " or ") + ")"
+	}
+
+	return ""
+}
+
+// tcpdumpField returns "tcp[offset:size] & mask"
+func tcpdumpField(field Field) string {
+
+	header := "ip"
+	if field.Header == HeaderTCP {
+		header = "tcp"
+	}
+
+	s := header + "[" + tcpdumpOffset(field.Offset, field.Lengths)
+	if field.Size > 1 {
+		s += ":" + strconv.Itoa(int(field.Size))
+	}
+	s += "]"
+
+	if field.Mask != 0 {
+		s += " & " + fmt.Sprintf("0x%02x", field.Mask)
+	}
+
+	return s
+}
+
+// tcpdumpOffset returns offset moved by values of length bytes, e.g. "24+tcp[21]"
+func tcpdumpOffset(offset uint32, lengths []uint32) string {
+
+	s := strconv.Itoa(int(offset))
+	for i, length := range lengths {
+		s += "+tcp[" + tcpdumpOffset(length, lengths[:i]) + "]"
+	}
+
+	return s
+}
+
+func tcpdumpOp(op Op) string {
+	if op == OpEqual {
+		return "="
+	}
+	return string(op)
+}
@@ -0,0 +1,35 @@
+package filter
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompileTcpdump(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, err := parser.Parse("4:64:0:*:mss*10,7:mss,sack,ws,eol+1:df,id+:0")
+	assert.Nil(t, err)
+
+	expression, err := CompileTcpdump(sig, signature.DirectionRequest)
+	assert.Nil(t, err)
+	assert.Equal(t, "ip[0] & 0xf0 = 0x40 and ip[9] = 6 and ip[6:2] & 0x1fff = 0 and ip[8] <= 64 and ip[8] >= 29 and "+
+		"ip[0] & 0x0f = 0x05 and ip[6] & 0x40 != 0 and ip[4:2] != 0 and ip[6] & 0x80 = 0 and "+
+		"ip[1] & 0x03 = 0 and tcp[13] & 0xc0 = 0 and tcp[12] & 0x01 = 0 and "+
+		"tcp[13] & 0x17 = 0x02 and tcp[4:4] != 0 and tcp[8:4] = 0 and tcp[13] & 0x20 = 0 and tcp[18:2] = 0 and tcp[13] & 0x08 = 0 and "+
+		"tcp[20] = 2 and tcp[21] = 4 and tcp[24] = 5 and tcp[24+tcp[25]] = 3 and tcp[25+tcp[25]] = 3 and "+
+		"tcp[27+tcp[25]] = 0 and tcp[28+tcp[25]] = 0 and (tcp[12] & 0xf0) >> 2 = 29+tcp[25] and "+
+		"tcp[26+tcp[25]] = 7 and tcp[14:2] = tcp[22:2] * 10 and "+
+		"ip[2:2] - ((ip[0] & 0x0f) << 2) - ((tcp[12] & 0xf0) >> 2) = 0", expression)
+
+	sig, _ = parser.Parse("*:64:0:*:%8192,*:mss,nop,eol+2:df,ecn,opt+:+")
+	expression, err = CompileTcpdump(sig, signature.DirectionResponse)
+	assert.Nil(t, err)
+	assert.Contains(t, expression, "(ip[1] & 0x03 != 0 or tcp[13] & 0xc0 != 0 or tcp[12] & 0x01 != 0)")
+	assert.Contains(t, expression, "(tcp[26] != 0 or tcp[27] != 0)")
+	assert.Contains(t, expression, "tcp[14:2] % 8192 = 0")
+	assert.Contains(t, expression, "tcp[13] & 0x17 = 0x12")
+	assert.Contains(t, expression, "tcp[12] & 0xf0 = 0x70")
+	assert.Contains(t, expression, "ip[2:2] > 48")
+}
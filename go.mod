@@ -5,10 +5,11 @@ go 1.21
 require (
 	github.com/google/gopacket v1.1.19
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/net v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,35 @@
+package p0f
+
+// HintPolicy defines how values of TCP options found in the packet are used
+// when the signature does not define exact values (wildcards)
+type HintPolicy int
+
+const (
+	// HintPreserveIfValid keeps value of the packet if it is allowed by the signature,
+	// random value is used otherwise
+	HintPreserveIfValid HintPolicy = 0
+	// HintRandomize always uses random value allowed by the signature
+	HintRandomize HintPolicy = 1
+	// HintFromConfig always uses value from HintPolicies as is, even if it is not
+	// allowed by the signature
+	HintFromConfig HintPolicy = 2
+)
+
+// HintPolicies sets hint policy per TCP option, zero value preserves valid values of the packet.
+// Values defined by the signature (exact MSS, exact window scale, "ts1-" quirk) always take precedence.
+type HintPolicies struct {
+	// MSS option value, used when signature has "*" MSS.
+	// Valid values are 536 up to the value that keeps "mss*N" window size within 16 bits.
+	MSS      HintPolicy
+	MSSValue uint16
+
+	// window scale option value, used when signature has "*" scale.
+	// Valid values are up to 14, or above 14 if signature has "exws" quirk.
+	WindowScale      HintPolicy
+	WindowScaleValue uint8
+
+	// own timestamp (TSval) of timestamps option, peer timestamp (TSecr) is always
+	// defined by "ts2+" quirk. Valid values are non-zero.
+	Timestamps     HintPolicy
+	TimestampValue uint32
+}
@@ -0,0 +1,143 @@
+package p0f
+
+import (
+	"encoding/binary"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"sync"
+	"sync/atomic"
+)
+
+// number of per-destination counters, same as the minimum number of IP ID buckets of Linux
+const ipIdBuckets = 2048
+
+// IpIdGenerator returns IP identification of the next packet, generators keep state
+// across packets and flows and are safe for concurrent use.
+// Signature quirks take precedence: zero ID is used when signature requires it,
+// and random ID is used when generator returns zero while signature requires non-zero ID.
+type IpIdGenerator interface {
+	NextId(ipv4 *layers.IPv4) uint16
+}
+
+// randomIpIdGenerator is implemented by generators of the package, they take random values
+// from the source of the caller, e.g. of WithRandSource, and NextId uses global source of math/rand
+type randomIpIdGenerator interface {
+	nextId(ipv4 *layers.IPv4, rnd random) uint16
+}
+
+// nextIpId returns ID of the generator with random values of rnd if the generator supports it
+func nextIpId(generator IpIdGenerator, ipv4 *layers.IPv4, rnd random) uint16 {
+	if g, ok := generator.(randomIpIdGenerator); ok {
+		return g.nextId(ipv4, rnd)
+	}
+	return generator.NextId(ipv4)
+}
+
+// SequentialIpId is a global counter incremented for every packet, e.g. old Windows and FreeBSD
+type SequentialIpId struct {
+	once    sync.Once
+	counter atomic.Uint32
+}
+
+// NewSequentialIpId creates global counter, it starts at random value on the first packet
+func NewSequentialIpId() *SequentialIpId {
+	return &SequentialIpId{}
+}
+
+func (g *SequentialIpId) NextId(ipv4 *layers.IPv4) uint16 {
+	return g.nextId(ipv4, globalRandom{})
+}
+
+func (g *SequentialIpId) nextId(_ *layers.IPv4, rnd random) uint16 {
+	g.once.Do(func() {
+		g.counter.Store(uint32(rnd.Intn(0x10000)))
+	})
+	return uint16(g.counter.Add(1))
+}
+
+// PerDestinationIpId keeps counters per source, destination and protocol, e.g. Linux and Solaris.
+// Like in Linux, counters are a fixed number of buckets selected by keyed hash of the addresses,
+// so unrelated destinations rarely share a counter.
+type PerDestinationIpId struct {
+	once     sync.Once
+	mu       sync.Mutex
+	key      uint64
+	counters [ipIdBuckets]uint16
+}
+
+// NewPerDestinationIpId creates counters, the key and counters are random values
+// set on the first packet
+func NewPerDestinationIpId() *PerDestinationIpId {
+	return &PerDestinationIpId{}
+}
+
+func (g *PerDestinationIpId) NextId(ipv4 *layers.IPv4) uint16 {
+	return g.nextId(ipv4, globalRandom{})
+}
+
+func (g *PerDestinationIpId) nextId(ipv4 *layers.IPv4, rnd random) uint16 {
+
+	g.once.Do(func() {
+		g.key = rnd.Uint64()
+		for i := range g.counters {
+			g.counters[i] = uint16(rnd.Intn(0x10000))
+		}
+	})
+
+	// FNV-1a of the key and addresses, inlined to avoid allocations per packet
+	hash := uint32(2166136261)
+	write := func(data []byte) {
+		for _, b := range data {
+			hash ^= uint32(b)
+			hash *= 16777619
+		}
+	}
+
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], g.key)
+	write(key[:])
+	write(ipv4.SrcIP.To4())
+	write(ipv4.DstIP.To4())
+	write([]byte{byte(ipv4.Protocol)})
+	bucket := hash % ipIdBuckets
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.counters[bucket]++
+	return g.counters[bucket]
+}
+
+// RandomIpId returns random non-zero ID for every packet, e.g. OpenBSD and Mac OS X
+type RandomIpId struct{}
+
+func (g RandomIpId) NextId(ipv4 *layers.IPv4) uint16 {
+	return g.nextId(ipv4, globalRandom{})
+}
+
+func (RandomIpId) nextId(_ *layers.IPv4, rnd random) uint16 {
+	return uint16(rnd.Intn(0xFFFF) + 1)
+}
+
+// ZeroIpId returns zero ID, e.g. Linux for packets with DF bit. Packets which require
+// non-zero ID by signature ("id+" quirk, no DF bit) get random ID.
+type ZeroIpId struct{}
+
+func (ZeroIpId) NextId(_ *layers.IPv4) uint16 {
+	return 0
+}
+
+// DefaultIpIdGenerator returns new generator used by the OS of the label,
+// random generator is used for unknown systems
+func DefaultIpIdGenerator(label *signature.Label) IpIdGenerator {
+
+	switch label.Name {
+	case "Windows", "FreeBSD", "NetBSD":
+		return NewSequentialIpId()
+	case "Linux", "Android", "Solaris":
+		return NewPerDestinationIpId()
+	}
+
+	// OpenBSD, Mac OS X, iOS
+	return RandomIpId{}
+}
@@ -0,0 +1,113 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// maxRandom returns the maximum value of Intn
+type maxRandom struct {
+	globalRandom
+}
+
+func (maxRandom) Intn(n int) int {
+	return n - 1
+}
+
+func TestIpIdGenerators(t *testing.T) {
+
+	first := &layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 2), Protocol: layers.IPProtocolTCP}
+	second := &layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 3), Protocol: layers.IPProtocolTCP}
+
+	// global counter is shared by destinations
+	sequential := NewSequentialIpId()
+	id := sequential.NextId(first)
+	assert.Equal(t, id+1, sequential.NextId(second))
+	assert.Equal(t, id+2, sequential.NextId(first))
+
+	// counter of the destination is not affected by other destinations
+	perDestination := NewPerDestinationIpId()
+	id = perDestination.NextId(first)
+	for i := 0; i < 10; i++ {
+		perDestination.NextId(second)
+	}
+	assert.Equal(t, id+1, perDestination.NextId(first))
+
+	for i := 0; i < 1000; i++ {
+		assert.NotZero(t, RandomIpId{}.NextId(first))
+	}
+	assert.Zero(t, ZeroIpId{}.NextId(first))
+
+	// random IDs cover the whole non-zero range
+	assert.Equal(t, uint16(0xFFFF), RandomIpId{}.nextId(first, maxRandom{}))
+	assert.Equal(t, uint16(0xFFFF), nextIpId(RandomIpId{}, first, maxRandom{}))
+
+	assert.IsType(t, &SequentialIpId{}, DefaultIpIdGenerator(&signature.Label{Name: "Windows"}))
+	assert.IsType(t, &PerDestinationIpId{}, DefaultIpIdGenerator(&signature.Label{Name: "Linux"}))
+	assert.IsType(t, RandomIpId{}, DefaultIpIdGenerator(&signature.Label{Name: "OpenBSD"}))
+}
+
+func TestSpoofIpLayerWithIdGenerator(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	var testData = []struct {
+		quirks    string
+		generator IpIdGenerator
+		zero      bool
+	}{
+		{"df,id+", NewSequentialIpId(), false},
+		{"df,id+", ZeroIpId{}, false},
+		{"df", NewSequentialIpId(), true},
+		{"", ZeroIpId{}, false},
+		{"id-", RandomIpId{}, true},
+	}
+
+	for _, item := range testData {
+		sig, err := parser.Parse("4:64:0:*:mss*10,0:mss:" + item.quirks + ":0")
+		assert.NoError(t, err)
+
+		ipv4 := &layers.IPv4{Id: 1}
+		SpoofIpLayerWithIdGenerator(ipv4, sig, item.generator)
+		assert.Equal(t, item.zero, ipv4.Id == 0, item.quirks)
+	}
+
+	// IDs of the generator follow each other
+	generator := NewSequentialIpId()
+	sig, _ := parser.Parse("4:64:0:*:mss*10,0:mss:df,id+:0")
+	ipv4 := &layers.IPv4{}
+	SpoofIpLayerWithIdGenerator(ipv4, sig, generator)
+	id := ipv4.Id
+	SpoofIpLayerWithIdGenerator(ipv4, sig, generator)
+	if id != 0xFFFF {
+		assert.Equal(t, id+1, ipv4.Id)
+	}
+}
+
+func TestIpIdGeneratorsRandSource(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("4:64:0:*:mss*10,0:mss:df,id+:0")
+
+	// generators start at values of the source of Spoofer
+	for _, generator := range []func() IpIdGenerator{
+		func() IpIdGenerator { return NewSequentialIpId() },
+		func() IpIdGenerator { return NewPerDestinationIpId() },
+		func() IpIdGenerator { return RandomIpId{} },
+	} {
+		var ids [2]uint16
+		for i := range ids {
+			spoofer, err := NewSpoofer(WithSignature(sig), WithRandSource(rand.NewSource(1)), WithIpIdGenerator(generator()))
+			assert.NoError(t, err)
+
+			ipv4 := &layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 2), Protocol: layers.IPProtocolTCP}
+			assert.NoError(t, spoofer.SpoofIPv4(ipv4, sig))
+			ids[i] = ipv4.Id
+		}
+		assert.Equal(t, ids[0], ids[1])
+	}
+}
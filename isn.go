@@ -0,0 +1,233 @@
+package p0f
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// ISN of the connection is kept for retransmitted SYNs during maximum segment lifetime
+	isnLifetime = 2 * time.Minute
+
+	// maximum number of kept tuples, the oldest are dropped first, so retransmissions of a few
+	// seconds keep ISN at hundreds of thousands of connections per second
+	isnMaxEntries = 1 << 20
+
+	// RFC 6528 clock ticks every 4 microseconds
+	isnClockTick = 4 * time.Microsecond
+
+	// 4.4BSD increments: per second and per connection
+	isnIncrementPerSecond     = 128000
+	isnIncrementPerConnection = 64000
+)
+
+// IsnGenerator returns initial sequence number of the connection, retransmitted SYNs
+// of the same connection tuple get the same ISN during 2 minutes, up to 1M the most recent
+// tuples are kept. Generators are safe for concurrent use.
+type IsnGenerator interface {
+	NextIsn(ip gopacket.NetworkLayer, tcp *layers.TCP) uint32
+}
+
+type isnTuple struct {
+	srcIP   netip.Addr
+	dstIP   netip.Addr
+	srcPort layers.TCPPort
+	dstPort layers.TCPPort
+}
+
+type isnEntry struct {
+	isn     uint32
+	created time.Time
+}
+
+type isnQueued struct {
+	tuple   isnTuple
+	created time.Time
+}
+
+// isnState keeps ISNs per connection tuple, tuples are queued in order of creation
+// to remove expired and the oldest ones without scanning the map
+type isnState struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	limit   int
+	entries map[isnTuple]isnEntry
+	queue   []isnQueued
+	head    int
+}
+
+func newIsnState() isnState {
+	return isnState{now: time.Now, limit: isnMaxEntries, entries: make(map[isnTuple]isnEntry)}
+}
+
+// next returns ISN of the tuple or a new one from generate, generate is called with the lock held
+func (s *isnState) next(ip gopacket.NetworkLayer, tcp *layers.TCP, generate func(tuple isnTuple, now time.Time) uint32) uint32 {
+
+	src, dst := ip.NetworkFlow().Endpoints()
+	srcIP, _ := netip.AddrFromSlice(src.Raw())
+	dstIP, _ := netip.AddrFromSlice(dst.Raw())
+	tuple := isnTuple{srcIP: srcIP, dstIP: dstIP, srcPort: tcp.SrcPort, dstPort: tcp.DstPort}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	if entry, found := s.entries[tuple]; found && now.Sub(entry.created) <= isnLifetime {
+		return entry.isn
+	}
+
+	isn := generate(tuple, now)
+	s.entries[tuple] = isnEntry{isn: isn, created: now}
+	s.queue = append(s.queue, isnQueued{tuple: tuple, created: now})
+	return isn
+}
+
+// prune removes expired tuples and the oldest ones over the limit, the lock is held
+func (s *isnState) prune(now time.Time) {
+
+	for s.head < len(s.queue) {
+		queued := s.queue[s.head]
+		if now.Sub(queued.created) <= isnLifetime && len(s.entries) < s.limit {
+			break
+		}
+
+		// the tuple may be created again after it is expired, then it is queued once more
+		if entry, found := s.entries[queued.tuple]; found && entry.created.Equal(queued.created) {
+			delete(s.entries, queued.tuple)
+		}
+		s.queue[s.head] = isnQueued{}
+		s.head++
+	}
+
+	// removed items are dropped from the queue when they are the most of it
+	if s.head > len(s.queue)/2 {
+		s.queue = append(s.queue[:0], s.queue[s.head:]...)
+		s.head = 0
+	}
+}
+
+// RFC6528Isn is ISN of modern stacks: 4 microseconds clock plus keyed hash of the connection tuple,
+// so ISNs of the tuple grow with time, while ISNs of different tuples are unrelated
+// https://datatracker.ietf.org/doc/html/rfc6528#section-3
+type RFC6528Isn struct {
+	isnState
+	key []byte
+}
+
+// NewRFC6528Isn creates generator with the secret key, random key of crypto/rand is used if it is empty
+func NewRFC6528Isn(key []byte) (*RFC6528Isn, error) {
+
+	if len(key) == 0 {
+		key = make([]byte, 16)
+		if _, err := crand.Read(key); err != nil {
+			return nil, fmt.Errorf("ISN secret key: %w", err)
+		}
+	}
+
+	return &RFC6528Isn{isnState: newIsnState(), key: key}, nil
+}
+
+func (g *RFC6528Isn) NextIsn(ip gopacket.NetworkLayer, tcp *layers.TCP) uint32 {
+	return g.next(ip, tcp, func(tuple isnTuple, now time.Time) uint32 {
+
+		// ISN = M + F(localip, localport, remoteip, remoteport, secretkey)
+		ports := make([]byte, 4)
+		binary.BigEndian.PutUint16(ports, uint16(tuple.srcPort))
+		binary.BigEndian.PutUint16(ports[2:], uint16(tuple.dstPort))
+
+		h := sha256.New()
+		h.Write(tuple.srcIP.AsSlice())
+		h.Write(ports[:2])
+		h.Write(tuple.dstIP.AsSlice())
+		h.Write(ports[2:])
+		h.Write(g.key)
+
+		clock := uint32(now.UnixNano() / int64(isnClockTick))
+		return clock + binary.BigEndian.Uint32(h.Sum(nil))
+	})
+}
+
+// RandomIsn is random ISN per connection, e.g. OpenBSD
+type RandomIsn struct {
+	isnState
+}
+
+func NewRandomIsn() *RandomIsn {
+	return &RandomIsn{isnState: newIsnState()}
+}
+
+func (g *RandomIsn) NextIsn(ip gopacket.NetworkLayer, tcp *layers.TCP) uint32 {
+	return g.next(ip, tcp, func(_ isnTuple, _ time.Time) uint32 {
+		return rand.Uint32()
+	})
+}
+
+// IncrementalIsn is a global counter of legacy stacks (4.4BSD, old Windows),
+// incremented by 64000 per connection and by 128000 per second
+type IncrementalIsn struct {
+	isnState
+	isn     uint32
+	updated time.Time
+}
+
+// NewIncrementalIsn creates counter starting at random value
+func NewIncrementalIsn() *IncrementalIsn {
+	return &IncrementalIsn{isnState: newIsnState(), isn: rand.Uint32()}
+}
+
+func (g *IncrementalIsn) NextIsn(ip gopacket.NetworkLayer, tcp *layers.TCP) uint32 {
+	return g.next(ip, tcp, func(_ isnTuple, now time.Time) uint32 {
+
+		if !g.updated.IsZero() {
+			g.isn += uint32(now.Sub(g.updated).Milliseconds() * isnIncrementPerSecond / 1000)
+		}
+		g.updated = now
+
+		g.isn += isnIncrementPerConnection
+		return g.isn
+	})
+}
+
+// DefaultIsnGenerator returns new generator used by the OS of the label,
+// RFC 6528 generator is used for unknown systems
+func DefaultIsnGenerator(label *signature.Label) IsnGenerator {
+
+	if label.Name == "OpenBSD" {
+		return NewRandomIsn()
+	}
+
+	generator, err := NewRFC6528Isn(nil)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+
+	return generator
+}
+
+// SpoofTcpIsn sets sequence number from the generator, sequence number
+// stays zero if signature has "seq-" quirk. IP layer is *layers.IPv4 or *layers.IPv6.
+func SpoofTcpIsn(ip gopacket.NetworkLayer, tcp *layers.TCP, sig *signature.Signature, generator IsnGenerator) {
+
+	if quirksOf(sig).SeqMinus {
+		tcp.Seq = 0
+		return
+	}
+
+	tcp.Seq = generator.NextIsn(ip, tcp)
+	if tcp.Seq == 0 {
+		// zero is "seq-" quirk
+		tcp.Seq = 1
+	}
+}
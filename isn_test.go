@@ -0,0 +1,97 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestIsnGenerators(t *testing.T) {
+
+	ipv4 := &layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 2)}
+	first := &layers.TCP{SrcPort: 40000, DstPort: 443}
+	second := &layers.TCP{SrcPort: 40001, DstPort: 443}
+
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+
+	// ISN of the tuple grows with 4 microseconds clock
+	rfc, err := NewRFC6528Isn([]byte("secret"))
+	assert.NoError(t, err)
+	rfc.now = clock.Now
+	isn := rfc.NextIsn(ipv4, first)
+	assert.NotEqual(t, isn, rfc.NextIsn(ipv4, second))
+
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, isn, rfc.NextIsn(ipv4, first), "retransmitted SYN")
+
+	clock.now = clock.now.Add(isnLifetime)
+	assert.Equal(t, isn+uint32((isnLifetime+time.Second)/isnClockTick), rfc.NextIsn(ipv4, first))
+
+	// the same key gives the same ISNs
+	other, _ := NewRFC6528Isn([]byte("secret"))
+	other.now = clock.Now
+	assert.Equal(t, rfc.NextIsn(ipv4, second), other.NextIsn(ipv4, second))
+
+	// random keys of crypto/rand give unrelated ISNs
+	random1, err := NewRFC6528Isn(nil)
+	assert.NoError(t, err)
+	random2, _ := NewRFC6528Isn(nil)
+	assert.Len(t, random1.key, 16)
+	assert.NotEqual(t, random1.key, random2.key)
+
+	// global counter
+	incremental := NewIncrementalIsn()
+	incremental.now = clock.Now
+	isn = incremental.NextIsn(ipv4, first)
+	assert.Equal(t, isn+isnIncrementPerConnection, incremental.NextIsn(ipv4, second))
+	assert.Equal(t, isn, incremental.NextIsn(ipv4, first), "retransmitted SYN")
+
+	clock.now = clock.now.Add(isnLifetime + time.Second)
+	assert.Equal(t, isn+2*isnIncrementPerConnection+uint32((isnLifetime+time.Second).Seconds()*isnIncrementPerSecond), incremental.NextIsn(ipv4, first))
+	assert.Len(t, incremental.entries, 1, "expired tuples are removed")
+
+	// the oldest tuples are dropped over the limit
+	incremental.limit = 2
+	third := &layers.TCP{SrcPort: 40002, DstPort: 443}
+	ipv6 := &layers.IPv6{SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+	isn = incremental.NextIsn(ipv4, second)
+	incremental.NextIsn(ipv4, third)
+	incremental.NextIsn(ipv6, first)
+	assert.Len(t, incremental.entries, 2)
+	assert.NotEqual(t, isn, incremental.NextIsn(ipv4, second), "dropped tuple")
+	assert.LessOrEqual(t, len(incremental.queue), 4)
+
+	random := NewRandomIsn()
+	isn = random.NextIsn(ipv4, first)
+	assert.Equal(t, isn, random.NextIsn(ipv4, first), "retransmitted SYN")
+
+	assert.IsType(t, &RandomIsn{}, DefaultIsnGenerator(&signature.Label{Name: "OpenBSD"}))
+	assert.IsType(t, &RFC6528Isn{}, DefaultIsnGenerator(&signature.Label{Name: "Linux"}))
+}
+
+func TestSpoofTcpIsn(t *testing.T) {
+
+	parser := signature.Parser{}
+	ipv4 := &layers.IPv4{SrcIP: net.IPv4(10, 0, 0, 1), DstIP: net.IPv4(10, 0, 0, 2)}
+	generator := NewIncrementalIsn()
+
+	sig, _ := parser.Parse("4:64:0:*:mss*10,0:mss:df,id+,seq-:0")
+	tcp := &layers.TCP{Seq: 1}
+	SpoofTcpIsn(ipv4, tcp, sig, generator)
+	assert.Zero(t, tcp.Seq)
+
+	sig, _ = parser.Parse("4:64:0:*:mss*10,0:mss:df,id+:0")
+	SpoofTcpIsn(ipv4, tcp, sig, generator)
+	assert.Equal(t, generator.isn, tcp.Seq)
+}
@@ -0,0 +1,357 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// MSS of commodity systems depends on the link, so it is replaced with "*"
+	learnerMinLinkMSS = 1300
+	learnerMaxLinkMSS = 1500
+
+	// systems alternating between a few scaling factors get a signature per factor, otherwise scale is "*"
+	learnerMaxScales = 3
+
+	// the smallest divisor of window sizes for "%N"
+	learnerMinWindowModulo = 1024
+)
+
+// Proposal is a signature generalized from observed signatures of the hint
+type Proposal struct {
+	Record *signature.Record
+	// number of observed signatures the proposal is made of
+	Samples int
+}
+
+// Learner collects observed signatures grouped by hint, e.g. User-Agent or a tag of the operator,
+// and proposes generalized signatures following "NEW SIGNATURES" notes of the p0f signature format:
+// IP version is "*", initial TTL is guessed, MSS of commodity links is "*", window size is detected
+// as "mss*N", "mtu*N" or "%N", scale is "*" if it varies more than between a few values. Options layout,
+// quirks, IP options length and payload class are copied literally, so a hint gets a signature for each
+// of their combinations and for each guessed initial TTL. Packets are counted by distinct values of the generalized fields, so memory
+// depends on variety of the packets rather than on their number. Learner is safe for concurrent use.
+type Learner struct {
+	mu     sync.Mutex
+	groups map[learnerKey]*learnerGroup
+	order  []learnerKey
+}
+
+type learnerKey struct {
+	hint         string
+	direction    signature.Direction
+	optionLength int
+	layout       string
+	quirks       signature.QuirkFlags
+	payloadSize  signature.PayloadSize
+}
+
+// learnerSample is a distinct value of the fields which are generalized, observed signatures
+// are aggregated by them, so memory is not growing with the number of packets
+type learnerSample struct {
+	version signature.IpVersion
+	ttl     int
+	mss     int
+	window  uint16
+	scale   int
+}
+
+type learnerGroup struct {
+	layout  []layers.TCPOptionKind
+	samples []learnerSample
+	counts  map[learnerSample]int
+}
+
+// NewLearner creates empty learner
+func NewLearner() *Learner {
+	return &Learner{groups: make(map[learnerKey]*learnerGroup)}
+}
+
+// Add records observed signature of SYN (request) or SYN+ACK (response) packet of the hint, see Observe
+func (l *Learner) Add(hint string, direction signature.Direction, observed *signature.Signature) {
+
+	key := learnerKey{
+		hint:         hint,
+		direction:    direction,
+		optionLength: observed.OptionLength,
+		layout:       layoutKey(observed.OptionsLayout),
+		payloadSize:  observed.PayloadSize,
+	}
+	if observed.Quirks != nil {
+		key.quirks = *observed.Quirks
+	}
+
+	sample := learnerSample{
+		version: observed.IpVersion,
+		ttl:     observed.InitialTTL,
+		mss:     observed.MaximumSegmentSize,
+		scale:   sampleScale(observed),
+	}
+	if observed.WindowSize != nil {
+		sample.window = observed.WindowSize.WindowSize
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	group, found := l.groups[key]
+	if !found {
+		group = &learnerGroup{layout: slices.Clone(observed.OptionsLayout), counts: make(map[learnerSample]int)}
+		l.groups[key] = group
+		l.order = append(l.order, key)
+	}
+	if group.counts[sample] == 0 {
+		group.samples = append(group.samples, sample)
+	}
+	group.counts[sample]++
+}
+
+// Propose returns generalized signatures made of at least minSamples observed signatures,
+// in order of hints, then by number of samples. Hints which are labels ("s:unix:Linux:3.x")
+// are used as labels, other hints become names of "other" class.
+func (l *Learner) Propose(minSamples int) []*Proposal {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	parser := signature.Parser{}
+	labels := make(map[string]*signature.Label)
+	var result []*Proposal
+
+	for _, key := range l.order {
+
+		label, found := labels[key.hint]
+		if !found {
+			label = learnerLabel(&parser, key.hint)
+			labels[key.hint] = label
+		}
+
+		group := l.groups[key]
+		for _, samples := range splitGroup(group.samples) {
+			count := 0
+			for _, sample := range samples {
+				count += group.counts[sample]
+			}
+			if count < minSamples {
+				continue
+			}
+
+			sig := generalize(key, group.layout, samples)
+			result = append(result, &Proposal{
+				Record: &signature.Record{
+					Label:     label,
+					Direction: key.direction,
+					Raw:       sig.String(),
+					Signature: sig,
+				},
+				Samples: count,
+			})
+		}
+	}
+
+	// groups of the same hint are next to each other, the most frequent go first
+	hints := make(map[*signature.Label]int)
+	for _, proposal := range result {
+		if _, found := hints[proposal.Record.Label]; !found {
+			hints[proposal.Record.Label] = len(hints)
+		}
+	}
+	slices.SortStableFunc(result, func(a, b *Proposal) int {
+		if order := hints[a.Record.Label] - hints[b.Record.Label]; order != 0 {
+			return order
+		}
+		return b.Samples - a.Samples
+	})
+
+	return result
+}
+
+// Database returns proposals as a database with classes of their labels, see Propose.
+// Use Database.WriteTo to get p0f.fp syntax.
+func (l *Learner) Database(minSamples int) *signature.Database {
+
+	db := &signature.Database{}
+	for _, proposal := range l.Propose(minSamples) {
+		// "!" is not a class, it marks applications
+		if class := proposal.Record.Label.Class; class != "!" && !slices.Contains(db.Classes, class) {
+			db.Classes = append(db.Classes, class)
+		}
+
+		if proposal.Record.Direction == signature.DirectionResponse {
+			db.Response = append(db.Response, proposal.Record)
+		} else {
+			db.Request = append(db.Request, proposal.Record)
+		}
+	}
+
+	return db
+}
+
+// learnerLabel returns the hint parsed as label or a label of "other" class named by the hint
+func learnerLabel(parser *signature.Parser, hint string) *signature.Label {
+
+	if label, err := parser.ParseLabel(hint); err == nil {
+		return label
+	}
+
+	name := strings.ReplaceAll(hint, ":", " ")
+	if name == "" {
+		name = "unknown"
+	}
+
+	return &signature.Label{Class: "other", Name: name}
+}
+
+// splitGroup splits samples by guessed initial TTL, then by window scale, see splitScales.
+// Initial TTL of the proposal is guessed from the largest TTL, so samples of other initial TTLs
+// would be too far from it to match.
+func splitGroup(samples []learnerSample) [][]learnerSample {
+
+	var ttls []int
+	for _, sample := range samples {
+		if ttl := GuessInitialTTL(sample.ttl); !slices.Contains(ttls, ttl) {
+			ttls = append(ttls, ttl)
+		}
+	}
+
+	byTTL := make([][]learnerSample, len(ttls))
+	for _, sample := range samples {
+		i := slices.Index(ttls, GuessInitialTTL(sample.ttl))
+		byTTL[i] = append(byTTL[i], sample)
+	}
+
+	var result [][]learnerSample
+	for _, samples := range byTTL {
+		result = append(result, splitScales(samples)...)
+	}
+
+	return result
+}
+
+// splitScales splits samples by window scale if there are a few scales, see learnerMaxScales
+func splitScales(samples []learnerSample) [][]learnerSample {
+
+	var scales []int
+	for _, sample := range samples {
+		if !slices.Contains(scales, sample.scale) {
+			scales = append(scales, sample.scale)
+		}
+	}
+
+	if len(scales) == 1 || len(scales) > learnerMaxScales {
+		return [][]learnerSample{samples}
+	}
+
+	result := make([][]learnerSample, len(scales))
+	for _, sample := range samples {
+		i := slices.Index(scales, sample.scale)
+		result[i] = append(result[i], sample)
+	}
+
+	return result
+}
+
+// generalize returns signature matching all samples of the group key with options layout
+func generalize(key learnerKey, layout []layers.TCPOptionKind, samples []learnerSample) *signature.Signature {
+
+	first := samples[0]
+	quirks := key.quirks
+	sig := &signature.Signature{
+		IpVersion:          signature.IpVersionAny,
+		OptionLength:       key.optionLength,
+		MaximumSegmentSize: first.mss,
+		PayloadSize:        key.payloadSize,
+		OptionsLayout:      slices.Clone(layout),
+		Quirks:             &quirks,
+		WindowSize: &signature.WindowSize{
+			WindowScalingFactor: first.scale,
+		},
+	}
+
+	// TTL of the packets is the observed initial TTL decreased by distance
+	maxTTL := 0
+	for _, sample := range samples {
+		maxTTL = max(maxTTL, sample.ttl)
+
+		if sample.mss != first.mss {
+			sig.MaximumSegmentSize = signature.MaximumSegmentSizeWildcardIntValue
+		}
+		if sample.scale != sig.WindowSize.WindowScalingFactor {
+			sig.WindowSize.WindowScalingFactor = signature.WindowScaleFactorWildcardIntValue
+		}
+	}
+	sig.InitialTTL = GuessInitialTTL(maxTTL)
+
+	if mss := sig.MaximumSegmentSize; mss >= learnerMinLinkMSS && mss <= learnerMaxLinkMSS {
+		sig.MaximumSegmentSize = signature.MaximumSegmentSizeWildcardIntValue
+	}
+
+	sig.WindowSize.WindowSize, sig.WindowSize.WindowSizeType = generalizeWindow(samples)
+
+	return sig
+}
+
+// generalizeWindow detects window size as multiple of MSS or MTU, the same value, multiple of a power
+// of two or any value, in this order
+func generalizeWindow(samples []learnerSample) (uint16, signature.WindowType) {
+
+	multiple := func(unit func(sample learnerSample) int) (uint16, bool) {
+		n := 0
+		for _, sample := range samples {
+			window, u := int(sample.window), unit(sample)
+			if u <= 0 || window == 0 || window%u != 0 || n != 0 && window/u != n {
+				return 0, false
+			}
+			n = window / u
+		}
+		return uint16(n), true
+	}
+
+	if n, ok := multiple(func(sample learnerSample) int {
+		return sample.mss
+	}); ok {
+		return n, signature.WindowTypeMSS
+	}
+
+	if n, ok := multiple(func(sample learnerSample) int {
+		if sample.mss == 0 {
+			return 0
+		}
+		return signature.MTU(sample.mss, sample.version)
+	}); ok {
+		return n, signature.WindowTypeMTU
+	}
+
+	window := samples[0].window
+	modulo := uint16(0)
+	same := true
+	for _, sample := range samples {
+		same = same && sample.window == window
+		modulo |= sample.window
+	}
+
+	if same {
+		return window, signature.WindowTypeNormal
+	}
+
+	// the largest power of two dividing all window sizes
+	if modulo != 0 {
+		if divisor := uint16(1) << bits.TrailingZeros16(modulo); divisor >= learnerMinWindowModulo {
+			return divisor, signature.WindowTypeMod
+		}
+	}
+
+	return 0, signature.WindowTypeAny
+}
+
+// sampleScale returns window scale of the observed signature, zero if it is not set
+func sampleScale(sample *signature.Signature) int {
+	if sample.WindowSize == nil {
+		return 0
+	}
+	return sample.WindowSize.WindowScalingFactor
+}
@@ -0,0 +1,178 @@
+package p0f
+
+import (
+	"bytes"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestLearner(t *testing.T) {
+
+	parser := signature.Parser{}
+	rnd := rand.New(rand.NewSource(1))
+	learner := NewLearner()
+
+	// observed signatures of packets spoofed for the signature with random MSS of the link and distance
+	learn := func(hint string, raw string, n int, synAck bool, mss func() uint16) []*signature.Signature {
+		sig, err := parser.Parse(raw)
+		assert.NoError(t, err, raw)
+
+		var samples []*signature.Signature
+		for i := 0; i < n; i++ {
+			spoofer, err := NewSpoofer(
+				WithSignature(sig),
+				WithRandSource(rand.NewSource(rnd.Int63())),
+				WithTTLDistance(rnd.Intn(20)),
+				WithHintPolicies(HintPolicies{MSS: HintFromConfig, MSSValue: mss()}),
+			)
+			assert.NoError(t, err)
+
+			ipv4, tcp := randomPacket(synAck)
+			assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+
+			direction := signature.DirectionRequest
+			if synAck {
+				direction = signature.DirectionResponse
+			}
+
+			observed := Observe(ipv4, tcp)
+			learner.Add(hint, direction, observed)
+			samples = append(samples, observed)
+		}
+
+		return samples
+	}
+	linkMss := func() uint16 {
+		return uint16(1300 + rnd.Intn(161))
+	}
+
+	var samples []*signature.Signature
+	samples = append(samples, learn("s:unix:Linux:6.x", "*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0", 20, false, linkMss)...)
+	samples = append(samples, learn("s:unix:Linux:6.x", "*:64:0:*:mss*10,0:mss:df,id+:0", 2, false, linkMss)...)
+	samples = append(samples, learn("Windows Chrome", "*:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0", 10, false, linkMss)...)
+	samples = append(samples, learn("Windows Chrome", "*:128:0:*:8192,2:mss,nop,ws,nop,nop,sok:df,id+:0", 5, false, linkMss)...)
+	samples = append(samples, learn("printer", "*:255:0:536:%2048,*:mss,nop,ws:df:0", 10, false, func() uint16 { return 536 })...)
+	samples = append(samples, learn("s:unix:Linux:6.x", "*:64:0:*:mtu*4,*:mss,sok,ts,nop,ws:df:0", 10, true, linkMss)...)
+
+	proposals := learner.Propose(3)
+	var raw []string
+	for _, proposal := range proposals {
+		raw = append(raw, proposal.Record.Label.String()+" "+proposal.Record.Raw)
+	}
+	assert.Equal(t, []string{
+		"s:unix:Linux:6.x *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0",
+		"s:unix:Linux:6.x *:64:0:*:mtu*4,*:mss,sok,ts,nop,ws:df:0",
+		"s:other:Windows Chrome: *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0",
+		"s:other:Windows Chrome: *:128:0:*:8192,2:mss,nop,ws,nop,nop,sok:df,id+:0",
+		"s:other:printer: *:255:0:536:%2048,*:mss,nop,ws:df:0",
+	}, raw)
+	assert.Equal(t, 20, proposals[0].Samples)
+	assert.Equal(t, signature.DirectionResponse, proposals[1].Record.Direction)
+
+	// proposals of enough samples match their samples
+	db := learner.Database(0)
+	assert.Len(t, db.Request, 5)
+	assert.Len(t, db.Response, 1)
+	for _, sample := range samples[:20] {
+		assert.Equal(t, "s:unix:Linux:6.x", db.Match(sample, signature.DirectionRequest).Label.String())
+	}
+	for _, sample := range samples[22:37] {
+		assert.Equal(t, "Windows Chrome", db.Match(sample, signature.DirectionRequest).Label.Name)
+	}
+
+	// p0f.fp syntax
+	buf := bytes.Buffer{}
+	_, err := db.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "label = s:other:printer:\nsig   = *:255:0:536:%2048,*:mss,nop,ws:df:0\n")
+	assert.Contains(t, buf.String(), "classes = unix,other\n")
+	assert.Equal(t, []string{"unix", "other"}, db.Classes)
+
+	written, err := parser.ParseDatabase(&buf)
+	assert.NoError(t, err)
+	assert.Empty(t, signature.Diff(db, written))
+
+	// the same packets are counted, not kept
+	learner = NewLearner()
+	for i := 0; i < 100; i++ {
+		learner.Add("printer", signature.DirectionRequest, samples[37])
+	}
+	for _, group := range learner.groups {
+		assert.Len(t, group.samples, 1)
+	}
+	assert.Equal(t, 100, learner.Propose(1)[0].Samples)
+}
+
+func TestLearnerInitialTTL(t *testing.T) {
+
+	parser := signature.Parser{}
+	learner := NewLearner()
+
+	// the same layout of a hint with initial TTLs 64 and 128
+	var samples []*signature.Signature
+	for i, raw := range []string{
+		"*:64:0:1460:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0",
+		"*:128:0:1460:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0",
+	} {
+		sig, err := parser.Parse(raw)
+		assert.NoError(t, err)
+
+		for distance := 0; distance < 10; distance++ {
+			spoofer, err := NewSpoofer(WithSignature(sig), WithTTLDistance(distance+i))
+			assert.NoError(t, err)
+
+			ipv4, tcp := randomPacket(false)
+			assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+
+			observed := Observe(ipv4, tcp)
+			learner.Add("Windows Chrome", signature.DirectionRequest, observed)
+			samples = append(samples, observed)
+		}
+	}
+
+	var raw []string
+	for _, proposal := range learner.Propose(1) {
+		raw = append(raw, proposal.Record.Raw)
+	}
+	assert.Equal(t, []string{
+		"*:64:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0",
+		"*:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0",
+	}, raw)
+
+	// every sample matches its proposal
+	db := learner.Database(1)
+	for _, sample := range samples {
+		record := db.Match(sample, signature.DirectionRequest)
+		if assert.NotNil(t, record, sample.String()) {
+			assert.Equal(t, GuessInitialTTL(sample.InitialTTL), record.Signature.InitialTTL, sample.String())
+		}
+	}
+}
+
+func TestGeneralizeWindow(t *testing.T) {
+
+	observed := func(mss int, window uint16) learnerSample {
+		return learnerSample{version: signature.IpVersion4, mss: mss, window: window}
+	}
+
+	var testData = []struct {
+		samples    []learnerSample
+		window     uint16
+		windowType signature.WindowType
+	}{
+		{[]learnerSample{observed(1460, 29200), observed(1400, 28000)}, 20, signature.WindowTypeMSS},
+		{[]learnerSample{observed(1460, 6000), observed(1400, 5760)}, 4, signature.WindowTypeMTU},
+		{[]learnerSample{observed(1460, 65535), observed(1400, 65535)}, 65535, signature.WindowTypeNormal},
+		{[]learnerSample{observed(0, 8192), observed(0, 8192)}, 8192, signature.WindowTypeNormal},
+		{[]learnerSample{observed(1460, 16384), observed(1400, 8192)}, 8192, signature.WindowTypeMod},
+		{[]learnerSample{observed(1460, 1000), observed(1400, 2000)}, 0, signature.WindowTypeAny},
+	}
+
+	for i, item := range testData {
+		window, windowType := generalizeWindow(item.samples)
+		assert.Equal(t, item.window, window, i)
+		assert.Equal(t, item.windowType, windowType, i)
+	}
+}
@@ -0,0 +1,200 @@
+package p0f
+
+import (
+	"encoding/binary"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+)
+
+// fixed sizes of TCP options, other sizes are malformed options ("bad" quirk)
+var tcpOptionSizes = map[layers.TCPOptionKind]int{
+	layers.TCPOptionKindMSS:           4,
+	layers.TCPOptionKindWindowScale:   3,
+	layers.TCPOptionKindSACKPermitted: 2,
+	layers.TCPOptionKindTimestamps:    10,
+}
+
+// Observe derives signature of the packet as p0f sees it, e.g. after spoofing or of the captured packet.
+// Values are exact: TTL of the packet is stored as InitialTTL, MSS and window scale are zero
+// if options are not set, window size has WindowTypeNormal type.
+// Use Compare of the reference signature to match it.
+func Observe(ipv4 *layers.IPv4, tcp *layers.TCP) *signature.Signature {
+
+	sig := &signature.Signature{
+		IpVersion:    signature.IpVersion4,
+		InitialTTL:   int(ipv4.TTL),
+		OptionLength: observeIpOptionLength(ipv4),
+	}
+
+	quirks := &signature.QuirkFlags{}
+
+	// IP header quirks, see SpoofIpLayer
+	quirks.DF = ipv4.Flags&layers.IPv4DontFragment != 0
+	quirks.IdPlus = quirks.DF && ipv4.Id != 0
+	quirks.IdMinus = !quirks.DF && ipv4.Id == 0
+	quirks.ZeroPlus = ipv4.Flags&layers.IPv4EvilBit != 0
+
+	// ECN is either of IP header or TCP header, see SpoofEcn
+	quirks.ECN = ipv4.TOS&ecnMask != ecnNotECT
+
+	observeTcp(tcp, sig, quirks)
+	return sig
+}
+
+// ObserveIpv6 is Observe for IPv6, hop limit is stored as InitialTTL
+func ObserveIpv6(ipv6 *layers.IPv6, tcp *layers.TCP) *signature.Signature {
+
+	sig := &signature.Signature{
+		IpVersion:  signature.IpVersion6,
+		InitialTTL: int(ipv6.HopLimit),
+	}
+
+	quirks := &signature.QuirkFlags{}
+
+	// IP header quirks, see SpoofIpv6Layer
+	quirks.Flow = ipv6.FlowLabel != 0
+	quirks.ECN = ipv6.TrafficClass&ecnMask != ecnNotECT
+
+	observeTcp(tcp, sig, quirks)
+	return sig
+}
+
+// observeTcp fills TCP part of the signature
+func observeTcp(tcp *layers.TCP, sig *signature.Signature, quirks *signature.QuirkFlags) {
+
+	sig.PayloadSize = signature.PayloadSizeZero
+	if len(tcp.Payload) > 0 {
+		sig.PayloadSize = signature.PayloadSizeNonZero
+	}
+
+	quirks.ECN = quirks.ECN || tcp.ECE || tcp.CWR || tcp.NS
+
+	// TCP header quirks, see SpoofTcpLayer
+	quirks.SeqMinus = tcp.Seq == 0
+	quirks.AckPlus = !tcp.ACK && tcp.Ack != 0
+	quirks.AckMinus = tcp.ACK && tcp.Ack == 0
+	quirks.UptrPlus = !tcp.URG && tcp.Urgent != 0
+	quirks.UrgfPlus = tcp.URG
+	quirks.PushfPlus = tcp.PSH
+
+	windowScale := observeTcpOptions(tcp, sig, quirks)
+
+	sig.WindowSize = &signature.WindowSize{
+		WindowSize:          tcp.Window,
+		WindowSizeType:      signature.WindowTypeNormal,
+		WindowScalingFactor: windowScale,
+	}
+	sig.Quirks = quirks
+}
+
+// Verify checks that the packet matches the signature, e.g. that spoofing of IP and TCP layers
+// is consistent, and returns fields which do not match
+func Verify(ipv4 *layers.IPv4, tcp *layers.TCP, sig *signature.Signature) []signature.Mismatch {
+	return sig.Compare(Observe(ipv4, tcp))
+}
+
+// VerifyIpv6 is Verify for IPv6
+func VerifyIpv6(ipv6 *layers.IPv6, tcp *layers.TCP, sig *signature.Signature) []signature.Mismatch {
+	return sig.Compare(ObserveIpv6(ipv6, tcp))
+}
+
+// observeIpOptionLength returns length of IP options aligned to 32 bit words as in IHL field
+func observeIpOptionLength(ipv4 *layers.IPv4) int {
+
+	length := 0
+	for _, option := range ipv4.Options {
+		switch option.OptionType {
+		case 0, 1:
+			// end of list and no operation
+			length++
+		default:
+			length += int(option.OptionLength)
+		}
+	}
+
+	if rem := length % 4; rem != 0 {
+		length += 4 - rem
+	}
+
+	return length
+}
+
+// observeTcpOptions fills options layout, MSS and options related quirks, returns window scale
+func observeTcpOptions(tcp *layers.TCP, sig *signature.Signature, quirks *signature.QuirkFlags) int {
+
+	windowScale := 0
+	headerLength := 0
+	if tcp.DataOffset > 5 {
+		headerLength = int(tcp.DataOffset)*4 - 20
+	}
+
+	length := 0
+	eol := false
+	for _, option := range tcp.Options {
+
+		sig.OptionsLayout = append(sig.OptionsLayout, option.OptionType)
+
+		if option.OptionType == layers.TCPOptionKindEndList {
+			eol = true
+			length++
+			break
+		}
+		if option.OptionType == layers.TCPOptionKindNop {
+			length++
+			continue
+		}
+
+		length += int(option.OptionLength)
+
+		// bad: malformed options
+		if size, found := tcpOptionSizes[option.OptionType]; found && int(option.OptionLength) != size {
+			quirks.Bad = true
+			continue
+		}
+
+		switch option.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(option.OptionData) >= 2 {
+				sig.MaximumSegmentSize = int(binary.BigEndian.Uint16(option.OptionData))
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(option.OptionData) >= 1 {
+				windowScale = int(option.OptionData[0])
+				// exws: excessive window scaling factor (> 14)
+				quirks.EXWS = windowScale > 14
+			}
+		case layers.TCPOptionKindTimestamps:
+			if len(option.OptionData) >= 8 {
+				// ts1-: own timestamp specified as zero
+				quirks.TsMinus = binary.BigEndian.Uint32(option.OptionData[:4]) == 0
+				// ts2+: non-zero peer timestamp on initial SYN
+				quirks.TsPlus = tcp.SYN && !tcp.ACK && binary.BigEndian.Uint32(option.OptionData[4:8]) != 0
+			}
+		}
+	}
+
+	// options are over header length
+	if headerLength > 0 && length > headerLength {
+		quirks.Bad = true
+	}
+
+	padding := tcp.Padding
+	if !eol && len(padding) > 0 {
+		// first byte of zero padding is the end of options list
+		sig.OptionsLayout = append(sig.OptionsLayout, layers.TCPOptionKindEndList)
+		padding = padding[1:]
+		eol = true
+	}
+
+	if eol {
+		for _, b := range padding {
+			sig.OptionsLayout = append(sig.OptionsLayout, layers.TCPOptionKindEndList)
+			// opt+: trailing non-zero data in options segment
+			if b != 0 {
+				quirks.OptPlus = true
+			}
+		}
+	}
+
+	return windowScale
+}
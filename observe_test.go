@@ -0,0 +1,132 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestObserve(t *testing.T) {
+
+	ipv4 := &layers.IPv4{TTL: 57, Flags: layers.IPv4DontFragment, Id: 1, TOS: 0b10}
+	tcp := &layers.TCP{
+		SYN:        true,
+		Seq:        1,
+		Window:     29200,
+		DataOffset: 10,
+		Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+			{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+			{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: []byte{0, 0, 0, 1, 0, 0, 0, 2}},
+			{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+			{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{15}},
+		},
+	}
+
+	assert.Equal(t, "4:57:0:1460:29200,15:mss,sok,ts,nop,ws:df,id+,ecn,ts2+,exws:0", Observe(ipv4, tcp).String())
+
+	// alignment padding is the end of options list, trailing data after it is "opt+"
+	tcp.Options = tcp.Options[:1]
+	tcp.Padding = []byte{0, 0, 1}
+	tcp.ACK = true
+	tcp.Payload = []byte{1}
+	ipv4.TOS = 0
+
+	assert.Equal(t, "4:57:0:1460:29200,0:mss,eol+2:df,id+,ack-,opt+:+", Observe(ipv4, tcp).String())
+
+	// malformed options
+	tcp.Options[0].OptionLength = 5
+	assert.True(t, Observe(ipv4, tcp).Quirks.Bad)
+}
+
+// TestVerifyDefaultDatabase spoofs random packets with every signature of the embedded database
+// and checks that signature of the packet matches, also after serialization
+func TestVerifyDefaultDatabase(t *testing.T) {
+
+	db := signature.DefaultDatabase()
+
+	for _, direction := range []signature.Direction{signature.DirectionRequest, signature.DirectionResponse} {
+		for _, record := range db.Records(direction) {
+			for n := 0; n < 20; n++ {
+
+				ipv4, tcp := randomPacket(direction == signature.DirectionResponse)
+				ipv4.TTL = uint8(min(record.Signature.InitialTTL, 255) - rand.Intn(signature.MaxDistance))
+
+				SpoofIpLayer(ipv4, record.Signature)
+				SpoofTcpLayer(tcp, record.Signature)
+				SpoofEcn(ipv4, tcp, record.Signature)
+
+				mismatches := Verify(ipv4, tcp, record.Signature)
+				if !assert.Empty(t, mismatches, "%s %s", record.Raw, Observe(ipv4, tcp)) {
+					break
+				}
+
+				// malformed options are not decoded by gopacket
+				if record.Signature.Quirks != nil && record.Signature.Quirks.Bad {
+					continue
+				}
+
+				packet := gopacket.NewPacket(serializeTestPacket(t, ipv4, tcp), layers.LayerTypeIPv4, gopacket.Default)
+				decodedIpv4, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+				decodedTcp, _ := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+				if !assert.NotNil(t, decodedIpv4) || !assert.NotNil(t, decodedTcp) {
+					break
+				}
+
+				mismatches = Verify(decodedIpv4, decodedTcp, record.Signature)
+				if !assert.Empty(t, mismatches, "%s %s", record.Raw, Observe(decodedIpv4, decodedTcp)) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func randomPacket(synAck bool) (*layers.IPv4, *layers.TCP) {
+
+	ipv4 := &layers.IPv4{
+		Version:  4,
+		TOS:      uint8(rand.Intn(0x100)),
+		Id:       uint16(rand.Intn(0x10000)),
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IPv4(192, 168, 0, 1),
+		DstIP:    net.IPv4(192, 168, 0, 2),
+	}
+
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(rand.Intn(0xFFFF) + 1),
+		DstPort: 443,
+		SYN:     true,
+		ACK:     synAck,
+		Seq:     rand.Uint32(),
+		Ack:     rand.Uint32(),
+		Window:  uint16(rand.Intn(0x10000)),
+	}
+
+	return ipv4, tcp
+}
+
+// serializeTestPacket serializes layers with checksums, TCP header of spoofed packets (data offset is set)
+// is kept as is, see ExactTcpLayer
+func serializeTestPacket(t testing.TB, ipv4 *layers.IPv4, tcp *layers.TCP) []byte {
+
+	var tcpLayer gopacket.SerializableLayer = tcp
+	if tcp.DataOffset != 0 {
+		tcpLayer = ExactTcpLayer{TCP: tcp}
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+		t.Fatal(err)
+	}
+	if err := gopacket.SerializeLayers(buf, opts, ipv4, tcpLayer, gopacket.Payload(tcp.Payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
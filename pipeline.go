@@ -0,0 +1,184 @@
+package p0f
+
+import (
+	"context"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// PipelinePacket is a serialized IPv4 or IPv6 packet with TCP layer processed by Pipeline
+type PipelinePacket struct {
+	// packet, it is replaced with the spoofed packet reusing its capacity
+	Data []byte
+	// signature of the connection, random signature of the profile is chosen if it is nil
+	Signature *signature.Signature
+	// error of spoofing, Data is not changed in this case
+	Err error
+}
+
+// Pipeline spoofs packets with a pool of workers, every worker has own random source
+// and reuses decoding and serialization buffers, so packets are processed with a few allocations.
+// IP ID and ISN generators of Spoofer or its profile are shared by workers, as they keep state
+// of the whole host, so workers serialize on locks of the generators if they are used.
+type Pipeline struct {
+	spoofer *Spoofer
+	workers int
+}
+
+// NewPipeline creates pipeline of workers, GOMAXPROCS workers are used if workers is not positive
+func NewPipeline(spoofer *Spoofer, workers int) *Pipeline {
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	return &Pipeline{spoofer: spoofer, workers: workers}
+}
+
+// Run spoofs packets of in and sends them to the returned channel until in is closed or ctx is done,
+// the returned channel is closed after that. Order of packets is not kept.
+func (p *Pipeline) Run(ctx context.Context, in <-chan *PipelinePacket) <-chan *PipelinePacket {
+
+	out := make(chan *PipelinePacket, p.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		// workers are seeded from the source of spoofer, so the pipeline is reproducible
+		// with WithRandSource if packets are processed by a single worker
+		w := newPipelineWorker(p.spoofer, p.spoofer.rnd.Int63n(math.MaxInt64))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, in, out)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+type pipelineWorker struct {
+	spoofer *Spoofer
+	rnd     *rand.Rand
+
+	ipv4    layers.IPv4
+	ipv6    layers.IPv6
+	tcp     layers.TCP
+	payload gopacket.Payload
+
+	ipv4Parser *gopacket.DecodingLayerParser
+	ipv6Parser *gopacket.DecodingLayerParser
+	decoded    []gopacket.LayerType
+
+	// options of decoded packet and options built by spoofing must not share memory
+	decodedOptions []layers.TCPOption
+	options        tcpOptionsBuffer
+	buf            gopacket.SerializeBuffer
+}
+
+func newPipelineWorker(spoofer *Spoofer, seed int64) *pipelineWorker {
+
+	w := &pipelineWorker{
+		spoofer: spoofer,
+		rnd:     rand.New(rand.NewSource(seed)),
+		decoded: make([]gopacket.LayerType, 0, 4),
+		buf:     gopacket.NewSerializeBuffer(),
+	}
+	w.decodedOptions = make([]layers.TCPOption, 0, maxTcpOptionsLength)
+	w.options.options = make([]layers.TCPOption, 0, maxTcpOptionsLength)
+
+	w.ipv4Parser = gopacket.NewDecodingLayerParser(layers.LayerTypeIPv4, &w.ipv4, &w.tcp, &w.payload)
+	w.ipv4Parser.IgnoreUnsupported = true
+	w.ipv6Parser = gopacket.NewDecodingLayerParser(layers.LayerTypeIPv6, &w.ipv6, &w.tcp, &w.payload)
+	w.ipv6Parser.IgnoreUnsupported = true
+
+	return w
+}
+
+func (w *pipelineWorker) run(ctx context.Context, in <-chan *PipelinePacket, out chan<- *PipelinePacket) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet, ok := <-in:
+			if !ok {
+				return
+			}
+
+			packet.Err = w.spoof(packet)
+
+			select {
+			case out <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// spoof decodes, spoofs and serializes the packet back to its Data
+func (w *pipelineWorker) spoof(packet *PipelinePacket) error {
+
+	if len(packet.Data) == 0 {
+		return ErrNotTcpPacket
+	}
+
+	sig := packet.Signature
+	if sig == nil {
+		var err error
+		if sig, err = w.spoofer.signature(w.rnd); err != nil {
+			return err
+		}
+	}
+
+	var ip gopacket.NetworkLayer
+	var ipLayer gopacket.SerializableLayer
+
+	parser := w.ipv4Parser
+	ip, ipLayer = &w.ipv4, &w.ipv4
+	if packet.Data[0]>>4 == 6 {
+		parser = w.ipv6Parser
+		ip, ipLayer = &w.ipv6, &w.ipv6
+	}
+
+	// options of the previous packet refer to the spoofing buffer, gopacket decodes into them
+	w.tcp = layers.TCP{Options: w.decodedOptions[:0]}
+	w.payload = nil
+
+	err := parser.DecodeLayers(packet.Data, &w.decoded)
+	w.decodedOptions = w.tcp.Options
+	if err != nil {
+		return err
+	}
+	if len(w.decoded) < 2 || w.decoded[1] != layers.LayerTypeTCP {
+		return ErrNotTcpPacket
+	}
+
+	w.options.reset()
+	if err := w.spoofer.spoofLayers(ip, &w.tcp, sig, w.rnd, &w.options); err != nil {
+		return err
+	}
+
+	if err := w.tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		return err
+	}
+
+	w.payload = w.tcp.Payload
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(w.buf, opts, ipLayer, ExactTcpLayer{TCP: &w.tcp}, &w.payload); err != nil {
+		return err
+	}
+
+	packet.Data = append(packet.Data[:0], w.buf.Bytes()...)
+	return nil
+}
@@ -0,0 +1,185 @@
+package p0f
+
+import (
+	"context"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testPipelineSignature = "*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0"
+
+// testSynPacket returns SYN with options of Linux
+func testSynPacket(t testing.TB) []byte {
+
+	ipv4, tcp := randomPacket(false)
+	tcp.Options = []layers.TCPOption{
+		{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+		{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+		{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: []byte{0, 0, 0, 1, 0, 0, 0, 0}},
+		{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+		{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+	}
+
+	return serializeTestPacket(t, ipv4, tcp)
+}
+
+func TestPipeline(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	other, _ := parser.Parse("*:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0")
+
+	spoofer, err := NewSpoofer(WithSignature(sig), WithStrict(true))
+	assert.NoError(t, err)
+
+	in := make(chan *PipelinePacket)
+	out := NewPipeline(spoofer, 4).Run(context.Background(), in)
+
+	// packets are built by the test goroutine, t.Fatal is not allowed in other goroutines
+	const count = 1000
+	packets := make([]*PipelinePacket, count)
+	for i := range packets {
+		packets[i] = &PipelinePacket{Data: testSynPacket(t)}
+		// signature of the connection
+		if i%2 == 1 {
+			packets[i].Signature = other
+		}
+	}
+
+	go func() {
+		for _, packet := range packets {
+			in <- packet
+		}
+		in <- &PipelinePacket{Data: []byte{0x45, 0}}
+		close(in)
+	}()
+
+	processed := 0
+	failed := 0
+	for packet := range out {
+		if packet.Err != nil {
+			failed++
+			continue
+		}
+		processed++
+
+		decoded := gopacket.NewPacket(packet.Data, layers.LayerTypeIPv4, gopacket.Default)
+		ipv4 := decoded.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		tcp := decoded.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+		expected := sig
+		if ipv4.TTL == 128 {
+			expected = other
+		}
+		assert.Empty(t, Verify(ipv4, tcp, expected))
+	}
+
+	assert.Equal(t, count, processed)
+	assert.Equal(t, 1, failed)
+}
+
+func TestPipelineCancel(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	spoofer, _ := NewSpoofer(WithSignature(sig))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan *PipelinePacket)
+	out := NewPipeline(spoofer, 0).Run(ctx, in)
+
+	in <- &PipelinePacket{Data: testSynPacket(t)}
+	cancel()
+
+	// output is closed without closing input
+	for range out {
+	}
+}
+
+func BenchmarkPipelineWorker(b *testing.B) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	spoofer, _ := NewSpoofer(WithSignature(sig))
+	worker := newPipelineWorker(spoofer, 1)
+
+	data := testSynPacket(b)
+	packet := &PipelinePacket{Data: make([]byte, 0, len(data))}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		packet.Data = append(packet.Data[:0], data...)
+		if err := worker.spoof(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	spoofer, _ := NewSpoofer(WithSignature(sig))
+
+	benchmarkPipeline(b, spoofer)
+}
+
+// workers share state of the generators and serialize on their locks
+func BenchmarkPipelineGenerators(b *testing.B) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	isn, _ := NewRFC6528Isn(nil)
+	spoofer, _ := NewSpoofer(WithSignature(sig), WithIpIdGenerator(NewSequentialIpId()), WithIsnGenerator(isn))
+
+	benchmarkPipeline(b, spoofer)
+}
+
+func benchmarkPipeline(b *testing.B, spoofer *Spoofer) {
+
+	data := testSynPacket(b)
+	packets := make(chan *PipelinePacket, 1024)
+	for i := 0; i < cap(packets); i++ {
+		packets <- &PipelinePacket{Data: make([]byte, 0, len(data))}
+	}
+
+	in := make(chan *PipelinePacket, 1024)
+	out := NewPipeline(spoofer, 0).Run(context.Background(), in)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			packet := <-packets
+			packet.Data = append(packet.Data[:0], data...)
+			in <- packet
+		}
+		close(in)
+	}()
+
+	// packets are returned to the pool of the caller
+	for packet := range out {
+		packets <- packet
+	}
+}
+
+func BenchmarkSpoofPacket(b *testing.B) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse(testPipelineSignature)
+	spoofer, _ := NewSpoofer(WithSignature(sig))
+	data := testSynPacket(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := spoofer.SpoofPacket(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
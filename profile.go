@@ -0,0 +1,282 @@
+package p0f
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"sort"
+	"sync"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile is a set of signatures sharing the same label, one of them is used per connection
+type Profile struct {
+	Label   *signature.Label
+	Records []*signature.Record
+	// relative weights of records, records are equally likely if not set
+	Weights []float64
+	// IP identification and ISN generators, profiles of the same OS name share them by default
+	IpId IpIdGenerator
+	Isn  IsnGenerator
+}
+
+func (p *Profile) choose(rnd random) *signature.Record {
+
+	if len(p.Weights) != len(p.Records) {
+		return p.Records[rnd.Intn(len(p.Records))]
+	}
+
+	return p.Records[chooseWeighted(p.Weights, rnd)]
+}
+
+// ProfileRegistry maps labels of the database to signatures, e.g. "s:win:Windows:7 or 8".
+// Profiles can also be looked up by "name:flavor" ("Windows:7 or 8") or just by name ("Windows"),
+// in this case signatures of all matching labels are rotated.
+// It is safe for concurrent use.
+type ProfileRegistry struct {
+	mu       sync.RWMutex
+	profiles map[string]*Profile
+	// label strings in order of the database
+	labels []string
+}
+
+// NewProfileRegistry creates registry from database records,
+// e.g. db.Request to look like a client or db.Response to look like a server
+func NewProfileRegistry(records []*signature.Record) *ProfileRegistry {
+
+	registry := &ProfileRegistry{
+		profiles: make(map[string]*Profile),
+	}
+
+	// one IP stack state per OS
+	stacks := make(map[string]*Profile)
+
+	for _, record := range records {
+		key := record.Label.String()
+
+		profile, found := registry.profiles[key]
+		if !found {
+			stack, found := stacks[record.Label.Name]
+			if !found {
+				stack = &Profile{IpId: DefaultIpIdGenerator(record.Label), Isn: DefaultIsnGenerator(record.Label)}
+				stacks[record.Label.Name] = stack
+			}
+
+			profile = &Profile{Label: record.Label, IpId: stack.IpId, Isn: stack.Isn}
+			registry.profiles[key] = profile
+			registry.labels = append(registry.labels, key)
+		}
+		profile.Records = append(profile.Records, record)
+	}
+
+	return registry
+}
+
+// Labels returns all labels of the registry
+func (r *ProfileRegistry) Labels() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.labels...)
+}
+
+// SetWeights sets relative weights of the label signatures in the order of the database,
+// nil makes them equally likely
+func (r *ProfileRegistry) SetWeights(label string, weights []float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	profile, found := r.profiles[label]
+	if !found {
+		return fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	if weights != nil {
+		if len(weights) != len(profile.Records) {
+			return fmt.Errorf("profile '%s' has %d signatures, %d weights given", label, len(profile.Records), len(weights))
+		}
+		if err := validateWeights(weights); err != nil {
+			return err
+		}
+	}
+
+	profile.Weights = append([]float64(nil), weights...)
+	return nil
+}
+
+// SetIpIdGenerator sets IP identification generator of the label, e.g. NewSequentialIpId(),
+// "name:flavor" or name sets it for all matching labels
+func (r *ProfileRegistry) SetIpIdGenerator(label string, generator IpIdGenerator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	profiles := r.lookup(label)
+	if len(profiles) == 0 {
+		return fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	for _, profile := range profiles {
+		profile.IpId = generator
+	}
+	return nil
+}
+
+// IpIdGenerator returns IP identification generator of the label to use with SpoofIpLayerWithIdGenerator,
+// generator of the first matching label is returned for "name:flavor" or name
+func (r *ProfileRegistry) IpIdGenerator(label string) (IpIdGenerator, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profiles := r.lookup(label)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	return profiles[0].IpId, nil
+}
+
+// SetIsnGenerator sets ISN generator of the label, e.g. NewIncrementalIsn(),
+// "name:flavor" or name sets it for all matching labels
+func (r *ProfileRegistry) SetIsnGenerator(label string, generator IsnGenerator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	profiles := r.lookup(label)
+	if len(profiles) == 0 {
+		return fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	for _, profile := range profiles {
+		profile.Isn = generator
+	}
+	return nil
+}
+
+// IsnGenerator returns ISN generator of the label to use with SpoofTcpIsn,
+// generator of the first matching label is returned for "name:flavor" or name
+func (r *ProfileRegistry) IsnGenerator(label string) (IsnGenerator, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profiles := r.lookup(label)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	return profiles[0].Isn, nil
+}
+
+// Choose returns random signature of the label, call it once per connection
+func (r *ProfileRegistry) Choose(label string) (*signature.Signature, error) {
+
+	record, err := r.ChooseRecord(label)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Signature, nil
+}
+
+// ChooseRecord is Choose which returns database record
+func (r *ProfileRegistry) ChooseRecord(label string) (*signature.Record, error) {
+	return r.chooseRecord(label, globalRandom{})
+}
+
+func (r *ProfileRegistry) chooseRecord(label string, rnd random) (*signature.Record, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profiles := r.lookup(label)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrProfileNotFound, label)
+	}
+
+	return profiles[rnd.Intn(len(profiles))].choose(rnd), nil
+}
+
+// ChooseWeighted picks one of the labels by relative weight and returns its random signature,
+// e.g. {"Windows:10": 3, "Linux": 1}
+func (r *ProfileRegistry) ChooseWeighted(labels map[string]float64) (*signature.Signature, error) {
+
+	if len(labels) == 0 {
+		return nil, ErrProfileNotFound
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	// map order is random, keep choices reproducible for the same random sequence
+	sort.Strings(names)
+
+	weights := make([]float64, 0, len(names))
+	for _, name := range names {
+		weights = append(weights, labels[name])
+	}
+
+	if err := validateWeights(weights); err != nil {
+		return nil, err
+	}
+
+	return r.Choose(names[chooseWeighted(weights, globalRandom{})])
+}
+
+// lookup returns profiles by full label, "name:flavor" or name
+func (r *ProfileRegistry) lookup(label string) []*Profile {
+
+	if profile, found := r.profiles[label]; found {
+		return []*Profile{profile}
+	}
+
+	var result []*Profile
+	for _, key := range r.labels {
+		profile := r.profiles[key]
+		if label == profile.Label.Name+":"+profile.Label.Flavor || label == profile.Label.Name {
+			result = append(result, profile)
+		}
+	}
+
+	return result
+}
+
+func validateWeights(weights []float64) error {
+
+	var sum float64
+	for _, w := range weights {
+		if w < 0 {
+			return fmt.Errorf("negative weight %v", w)
+		}
+		sum += w
+	}
+
+	if sum <= 0 {
+		return errors.New("sum of weights must be positive")
+	}
+
+	return nil
+}
+
+func chooseWeighted(weights []float64, rnd random) int {
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+
+	n := rnd.Float64() * sum
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+
+	// float rounding, last item with non-zero weight
+	for i := len(weights) - 1; i > 0; i-- {
+		if weights[i] > 0 {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const testProfiles = `
+[tcp:request]
+
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:unix:Linux:2.6.x
+sig   = *:64:0:*:mss*4,6:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+`
+
+func TestProfileRegistry(t *testing.T) {
+
+	parser := signature.Parser{}
+	db, err := parser.ParseDatabase(strings.NewReader(testProfiles))
+	assert.NoError(t, err)
+
+	registry := NewProfileRegistry(db.Request)
+	assert.Equal(t, []string{"s:unix:Linux:3.11 and newer", "s:unix:Linux:2.6.x", "s:win:Windows:7 or 8"}, registry.Labels())
+
+	_, err = registry.Choose("s:win:Windows:10")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+
+	sig, err := registry.Choose("s:win:Windows:7 or 8")
+	assert.NoError(t, err)
+	assert.Same(t, db.Request[3].Signature, sig)
+
+	sig, err = registry.Choose("Windows:7 or 8")
+	assert.NoError(t, err)
+	assert.Same(t, db.Request[3].Signature, sig)
+
+	// all variants of all Linux labels are rotated
+	seen := make(map[*signature.Signature]bool)
+	for i := 0; i < 1000; i++ {
+		sig, err = registry.Choose("Linux")
+		assert.NoError(t, err)
+		seen[sig] = true
+	}
+	assert.Len(t, seen, 3)
+
+	// weights of variants
+	assert.Error(t, registry.SetWeights("s:unix:Linux:3.11 and newer", []float64{1}))
+	assert.Error(t, registry.SetWeights("s:unix:Linux:3.11 and newer", []float64{-1, 2}))
+	assert.ErrorIs(t, registry.SetWeights("Linux", []float64{1, 0}), ErrProfileNotFound)
+	assert.NoError(t, registry.SetWeights("s:unix:Linux:3.11 and newer", []float64{0, 1}))
+	for i := 0; i < 100; i++ {
+		sig, err = registry.Choose("Linux:3.11 and newer")
+		assert.NoError(t, err)
+		assert.Same(t, db.Request[1].Signature, sig)
+	}
+
+	// weights of labels
+	for i := 0; i < 100; i++ {
+		sig, err = registry.ChooseWeighted(map[string]float64{"Linux:2.6.x": 0, "Windows": 1})
+		assert.NoError(t, err)
+		assert.Same(t, db.Request[3].Signature, sig)
+	}
+
+	_, err = registry.ChooseWeighted(map[string]float64{"Windows": 0})
+	assert.Error(t, err)
+}
+
+func TestProfileRegistryGenerators(t *testing.T) {
+
+	parser := signature.Parser{}
+	db, err := parser.ParseDatabase(strings.NewReader(testProfiles))
+	assert.NoError(t, err)
+
+	registry := NewProfileRegistry(db.Request)
+
+	// labels of the same OS share generator
+	linux, err := registry.IpIdGenerator("Linux:2.6.x")
+	assert.NoError(t, err)
+	assert.IsType(t, &PerDestinationIpId{}, linux)
+	generator, _ := registry.IpIdGenerator("s:unix:Linux:3.11 and newer")
+	assert.Same(t, linux, generator)
+
+	generator, _ = registry.IpIdGenerator("Windows")
+	assert.IsType(t, &SequentialIpId{}, generator)
+
+	_, err = registry.IpIdGenerator("OpenBSD")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+
+	assert.NoError(t, registry.SetIpIdGenerator("Linux", ZeroIpId{}))
+	generator, _ = registry.IpIdGenerator("Linux:3.11 and newer")
+	assert.Equal(t, ZeroIpId{}, generator)
+	assert.ErrorIs(t, registry.SetIpIdGenerator("OpenBSD", ZeroIpId{}), ErrProfileNotFound)
+
+	isn, err := registry.IsnGenerator("Linux:2.6.x")
+	assert.NoError(t, err)
+	assert.IsType(t, &RFC6528Isn{}, isn)
+	otherIsn, _ := registry.IsnGenerator("Linux:3.11 and newer")
+	assert.Same(t, isn, otherIsn)
+	otherIsn, _ = registry.IsnGenerator("Windows")
+	assert.NotSame(t, isn, otherIsn)
+
+	incremental := NewIncrementalIsn()
+	assert.NoError(t, registry.SetIsnGenerator("Windows:7 or 8", incremental))
+	otherIsn, _ = registry.IsnGenerator("s:win:Windows:7 or 8")
+	assert.Same(t, incremental, otherIsn)
+	_, err = registry.IsnGenerator("OpenBSD")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+}
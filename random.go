@@ -0,0 +1,73 @@
+package p0f
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// random is a source of random values used by spoofing, *rand.Rand implements it.
+// Free functions use global source of math/rand, Spoofer may have its own source.
+type random interface {
+	Intn(n int) int
+	Int31n(n int32) int32
+	Int63n(n int64) int64
+	Uint32() uint32
+	Uint64() uint64
+	Float64() float64
+}
+
+// globalRandom is the global source of math/rand, it is safe for concurrent use
+type globalRandom struct{}
+
+func (globalRandom) Intn(n int) int       { return rand.Intn(n) }
+func (globalRandom) Int31n(n int32) int32 { return rand.Int31n(n) }
+func (globalRandom) Int63n(n int64) int64 { return rand.Int63n(n) }
+func (globalRandom) Uint32() uint32       { return rand.Uint32() }
+func (globalRandom) Uint64() uint64       { return rand.Uint64() }
+func (globalRandom) Float64() float64     { return rand.Float64() }
+
+// lockedRandom makes source safe for concurrent use, like the global source of math/rand
+type lockedRandom struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRandom(src rand.Source) *lockedRandom {
+	return &lockedRandom{r: rand.New(src)}
+}
+
+func (l *lockedRandom) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRandom) Int31n(n int32) int32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int31n(n)
+}
+
+func (l *lockedRandom) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (l *lockedRandom) Uint32() uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Uint32()
+}
+
+func (l *lockedRandom) Uint64() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Uint64()
+}
+
+func (l *lockedRandom) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
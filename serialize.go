@@ -0,0 +1,20 @@
+package p0f
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ExactTcpLayer serializes TCP layer keeping options, padding and header length exactly
+// as they are set by SpoofTcpOptions. With FixLengths option gopacket recalculates option
+// lengths and replaces padding with zeros, so "bad" and "opt+" quirks are lost.
+//
+//	gopacket.SerializeLayers(buf, opts, ipLayer, p0f.ExactTcpLayer{TCP: tcpLayer})
+type ExactTcpLayer struct {
+	*layers.TCP
+}
+
+func (l ExactTcpLayer) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	opts.FixLengths = false
+	return l.TCP.SerializeTo(b, opts)
+}
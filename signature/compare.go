@@ -0,0 +1,249 @@
+package signature
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type DuplicateKind string
+type Change string
+
+const (
+	// DuplicateExact is the same signature string
+	DuplicateExact DuplicateKind = "exact"
+	// DuplicateEquivalent differs only in notation, e.g. order of quirks
+	DuplicateEquivalent DuplicateKind = "equivalent"
+
+	ChangeAdded   Change = "+"
+	ChangeRemoved Change = "-"
+)
+
+// Duplicate is a record which repeats an earlier record of the same label and direction
+type Duplicate struct {
+	Kind      DuplicateKind
+	Original  *Record
+	Duplicate *Record
+}
+
+// Conflict is a signature which is mapped to more than one label
+type Conflict struct {
+	Direction Direction
+	// canonical signature
+	Signature string
+	// first record of every label
+	Records []*Record
+}
+
+// DiffEntry is a record added to or removed from the database
+type DiffEntry struct {
+	Change Change
+	Record *Record
+}
+
+func (e DiffEntry) String() string {
+	return fmt.Sprintf("%s [tcp:%s] %s = %s", e.Change, e.Record.Direction, e.Record.Label, e.Record.Signature)
+}
+
+// recordKey identifies equivalent records
+func recordKey(r *Record) string {
+	return string(r.Direction) + "|" + r.Label.String() + "|" + r.Signature.String()
+}
+
+// signatureKey identifies equivalent signatures regardless of the label
+func signatureKey(r *Record) string {
+	return string(r.Direction) + "|" + r.Signature.String()
+}
+
+func (db *Database) records() []*Record {
+	return append(append([]*Record(nil), db.Request...), db.Response...)
+}
+
+// Duplicates returns exact and equivalent duplicates in order of the database
+func (db *Database) Duplicates() []Duplicate {
+
+	var result []Duplicate
+	seen := make(map[string]*Record)
+
+	for _, record := range db.records() {
+		key := recordKey(record)
+
+		original, found := seen[key]
+		if !found {
+			seen[key] = record
+			continue
+		}
+
+		kind := DuplicateEquivalent
+		if original.Raw == record.Raw {
+			kind = DuplicateExact
+		}
+
+		result = append(result, Duplicate{
+			Kind:      kind,
+			Original:  original,
+			Duplicate: record,
+		})
+	}
+
+	return result
+}
+
+// Dedup returns a new database without duplicates, the first record and the first link of MTU are kept
+func (db *Database) Dedup() *Database {
+
+	result := &Database{Classes: append([]string(nil), db.Classes...)}
+	seen := make(map[string]bool)
+
+	// the first label of MTU is the only one used by Database.Link
+	mtus := make(map[int]bool)
+	for _, link := range db.Links {
+		if !mtus[link.MTU] {
+			mtus[link.MTU] = true
+			result.Links = append(result.Links, link)
+		}
+	}
+
+	for _, record := range db.records() {
+		key := recordKey(record)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if record.Direction == DirectionResponse {
+			result.Response = append(result.Response, record)
+		} else {
+			result.Request = append(result.Request, record)
+		}
+	}
+
+	return result
+}
+
+// Conflicts returns signatures which are mapped to different labels in order of the database
+func (db *Database) Conflicts() []Conflict {
+
+	var keys []string
+	labels := make(map[string][]*Record)
+
+	for _, record := range db.records() {
+		key := signatureKey(record)
+
+		records, found := labels[key]
+		if !found {
+			keys = append(keys, key)
+		}
+
+		known := false
+		for _, r := range records {
+			if r.Label.String() == record.Label.String() {
+				known = true
+				break
+			}
+		}
+		if !known {
+			labels[key] = append(records, record)
+		}
+	}
+
+	var result []Conflict
+	for _, key := range keys {
+		records := labels[key]
+		if len(records) < 2 {
+			continue
+		}
+		result = append(result, Conflict{
+			Direction: records[0].Direction,
+			Signature: records[0].Signature.String(),
+			Records:   records,
+		})
+	}
+
+	return result
+}
+
+// Diff returns records removed from the previous database followed by records added to the current one,
+// equivalent records are the same, order of records is not compared
+func Diff(previous *Database, current *Database) []DiffEntry {
+
+	previousKeys := make(map[string]bool)
+	for _, record := range previous.records() {
+		previousKeys[recordKey(record)] = true
+	}
+
+	currentKeys := make(map[string]bool)
+	for _, record := range current.records() {
+		currentKeys[recordKey(record)] = true
+	}
+
+	var result []DiffEntry
+
+	for _, record := range previous.Dedup().records() {
+		if !currentKeys[recordKey(record)] {
+			result = append(result, DiffEntry{Change: ChangeRemoved, Record: record})
+		}
+	}
+
+	for _, record := range current.Dedup().records() {
+		if !previousKeys[recordKey(record)] {
+			result = append(result, DiffEntry{Change: ChangeAdded, Record: record})
+		}
+	}
+
+	return result
+}
+
+// WriteTo writes database in p0f.fp format, signatures are written as they were read,
+// "sys" lines follow labels of records with systems
+func (db *Database) WriteTo(w io.Writer) (int64, error) {
+
+	b := strings.Builder{}
+
+	if len(db.Classes) > 0 {
+		b.WriteString("classes = " + strings.Join(db.Classes, ",") + "\n")
+	}
+
+	if len(db.Links) > 0 {
+		b.WriteString("\n[" + sectionMTU + "]\n")
+
+		label := ""
+		for _, link := range db.Links {
+			if link.Label != label {
+				label = link.Label
+				b.WriteString("\nlabel = " + label + "\n")
+			}
+			b.WriteString("sig   = " + strconv.Itoa(link.MTU) + "\n")
+		}
+	}
+
+	for _, direction := range []Direction{DirectionRequest, DirectionResponse} {
+		records := db.Records(direction)
+		if len(records) == 0 {
+			continue
+		}
+
+		b.WriteString("\n[" + sectionTCP + ":" + string(direction) + "]\n")
+
+		label, sys := "", ""
+		for _, record := range records {
+			if record.Label.String() != label || strings.Join(record.Sys, ",") != sys {
+				label, sys = record.Label.String(), strings.Join(record.Sys, ",")
+				b.WriteString("\nlabel = " + label + "\n")
+				if sys != "" {
+					b.WriteString("sys   = " + sys + "\n")
+				}
+			}
+
+			raw := record.Raw
+			if raw == "" {
+				raw = record.Signature.String()
+			}
+			b.WriteString("sig   = " + raw + "\n")
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,100 @@
+package signature
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const testLocalDatabase = `
+[tcp:request]
+
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:id+,df:0
+
+label = s:unix:Linux:5.x
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+[tcp:response]
+
+label = g:unix:Linux:2.4-2.6
+sig   = *:64:0:*:mss*4,0:mss:df:0
+`
+
+func TestDatabaseCompare(t *testing.T) {
+
+	p := Parser{}
+
+	upstream, err := p.ParseDatabase(strings.NewReader(testDatabase))
+	assert.NoError(t, err)
+
+	local, err := p.ParseDatabase(strings.NewReader(testLocalDatabase))
+	assert.NoError(t, err)
+
+	merged := local.Merge(upstream)
+	assert.Len(t, merged.Request, 7)
+	assert.Len(t, merged.Response, 2)
+
+	duplicates := merged.Duplicates()
+	if assert.Len(t, duplicates, 4) {
+		assert.Equal(t, DuplicateEquivalent, duplicates[0].Kind)
+		assert.Equal(t, 5, duplicates[0].Original.Line)
+		assert.Equal(t, 6, duplicates[0].Duplicate.Line)
+
+		assert.Equal(t, DuplicateExact, duplicates[1].Kind)
+		assert.Equal(t, DuplicateExact, duplicates[2].Kind)
+		assert.Equal(t, DuplicateExact, duplicates[3].Kind)
+		assert.Equal(t, DirectionResponse, duplicates[3].Duplicate.Direction)
+	}
+
+	deduped := merged.Dedup()
+	assert.Len(t, deduped.Request, 4)
+	assert.Len(t, deduped.Response, 1)
+	assert.Empty(t, deduped.Duplicates())
+
+	conflicts := merged.Conflicts()
+	if assert.Len(t, conflicts, 1) {
+		assert.Equal(t, "*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0", conflicts[0].Signature)
+		assert.Equal(t, "s:unix:Linux:5.x", conflicts[0].Records[0].Label.String())
+		assert.Equal(t, "s:unix:Linux:3.11 and newer", conflicts[0].Records[1].Label.String())
+	}
+
+	diff := Diff(upstream, local)
+	if assert.Len(t, diff, 2) {
+		assert.Equal(t, "- [tcp:request] s:unix:Linux:3.11 and newer = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0", diff[0].String())
+		assert.Equal(t, "+ [tcp:request] s:unix:Linux:5.x = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0", diff[1].String())
+	}
+	assert.Empty(t, Diff(merged, deduped))
+
+	// written database is read back the same
+	buf := bytes.Buffer{}
+	_, err = deduped.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	written, err := p.ParseDatabase(&buf)
+	assert.NoError(t, err)
+	assert.Empty(t, Diff(deduped, written))
+	assert.Equal(t, deduped.Classes, written.Classes)
+	assert.Len(t, written.Request, 4)
+
+	// systems of application labels are written back
+	buf.Reset()
+	_, err = DefaultDatabase().WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "label = s:!:NMap:SYN scan\nsys   = @unix,@win\nsig   = ")
+
+	written, err = p.ParseDatabase(&buf)
+	assert.NoError(t, err)
+	for _, record := range written.Request {
+		if record.Label.Name == "NMap" {
+			assert.Equal(t, []string{"@unix", "@win"}, record.Sys)
+		} else {
+			assert.Nil(t, record.Sys)
+		}
+	}
+}
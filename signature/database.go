@@ -0,0 +1,296 @@
+package signature
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Direction string
+
+const (
+	DirectionRequest  Direction = "request"  // SYN
+	DirectionResponse Direction = "response" // SYN+ACK
+
+	sectionTCP = "tcp"
+	sectionMTU = "mtu"
+
+	labelTypeSpecific = "s"
+	labelTypeGeneric  = "g"
+)
+
+// Label of the signature, "s:win:Windows:7 or 8"
+// https://lcamtuf.coredump.cx/p0f3/README
+type Label struct {
+	// "g" labels are generic signatures which are used only if there is no specific match
+	Generic bool
+	// OS class ("win", "unix", "other"), "!" for applications
+	Class  string
+	Name   string
+	Flavor string
+}
+
+func (l *Label) String() string {
+	labelType := labelTypeSpecific
+	if l.Generic {
+		labelType = labelTypeGeneric
+	}
+	return labelType + ":" + l.Class + ":" + l.Name + ":" + l.Flavor
+}
+
+// Record is a signature of the database with its label
+type Record struct {
+	Label     *Label    `json:"label" yaml:"label"`
+	Direction Direction `json:"direction" yaml:"direction"`
+	// signature as it is written in the database
+	Raw       string     `json:"raw,omitempty" yaml:"raw,omitempty"`
+	Signature *Signature `json:"sig" yaml:"sig"`
+	// systems of application labels ("!" class), e.g. "@unix,@win" of NMap
+	Sys []string `json:"sys,omitempty" yaml:"sys,omitempty,flow"`
+	// source file, set by Parser.LoadDatabase, empty for the embedded database and readers
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// line in the source file
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+// Database of TCP signatures and MTUs in p0f.fp format,
+// sections other than [tcp:request], [tcp:response] and [mtu] are skipped
+type Database struct {
+	Classes  []string  `json:"classes,omitempty" yaml:"classes,omitempty,flow"`
+	Request  []*Record `json:"request" yaml:"request"`
+	Response []*Record `json:"response" yaml:"response"`
+	Links    []*Link   `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+}
+
+// Records returns signatures of the given direction
+func (db *Database) Records(direction Direction) []*Record {
+	if direction == DirectionResponse {
+		return db.Response
+	}
+	return db.Request
+}
+
+// Merge returns a new database with signatures and links of db followed by those of others,
+// classes are merged keeping their order
+func (db *Database) Merge(others ...*Database) *Database {
+
+	result := &Database{}
+
+	for _, source := range append([]*Database{db}, others...) {
+		for _, class := range source.Classes {
+			if !slices.Contains(result.Classes, class) {
+				result.Classes = append(result.Classes, class)
+			}
+		}
+		result.Request = append(result.Request, source.Request...)
+		result.Response = append(result.Response, source.Response...)
+		result.Links = append(result.Links, source.Links...)
+	}
+
+	return result
+}
+
+// LoadDatabase reads p0f.fp file, records keep the path in Record.File
+func (parser *Parser) LoadDatabase(path string) (*Database, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db, err := parser.ParseDatabase(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range db.records() {
+		record.File = path
+	}
+
+	return db, nil
+}
+
+// ParseDatabase reads database in p0f.fp format, errors have *ParseError type with the line number set
+func (parser *Parser) ParseDatabase(r io.Reader) (*Database, error) {
+
+	db := &Database{}
+
+	var section string
+	var direction string
+	var label *Label
+	var sys []string
+	var linkLabel string
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// comments start with semicolon
+		if line == "" || line[0] == ';' {
+			continue
+		}
+
+		// [module:direction] or [mtu]
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, parser.lineError(newTokenError("invalid section", line, 0), lineNumber)
+			}
+
+			section, direction, _ = strings.Cut(line[1:len(line)-1], ":")
+			label = nil
+			sys = nil
+			linkLabel = ""
+
+			if section == sectionTCP && direction != string(DirectionRequest) && direction != string(DirectionResponse) {
+				return nil, parser.lineError(newTokenError("invalid section", line, 0), lineNumber)
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, parser.lineError(newTokenError("invalid line", line, 0), lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "classes" {
+			db.Classes = strings.Split(value, ",")
+			continue
+		}
+
+		if section == sectionMTU {
+			if err := parser.parseLinkLine(db, key, value, &linkLabel, lineNumber); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if section != sectionTCP {
+			continue
+		}
+
+		switch key {
+		case "label":
+			l, err := parser.ParseLabel(value)
+			if err != nil {
+				return nil, parser.lineError(err, lineNumber)
+			}
+			label = l
+			sys = nil
+
+		case "sys":
+			// systems of the label, kept to write the database back
+			if label == nil {
+				return nil, parser.lineError(newTokenError("sys without label", value, 0), lineNumber)
+			}
+			sys = strings.Split(value, ",")
+			for i := range sys {
+				sys[i] = strings.TrimSpace(sys[i])
+			}
+
+		case "sig":
+			if label == nil {
+				return nil, parser.lineError(newTokenError("signature without label", value, 0), lineNumber)
+			}
+
+			sig, err := parser.Parse(value)
+			if err != nil {
+				return nil, parser.lineError(err, lineNumber)
+			}
+
+			record := &Record{
+				Label:     label,
+				Direction: Direction(direction),
+				Raw:       value,
+				Signature: sig,
+				Sys:       sys,
+				Line:      lineNumber,
+			}
+
+			if record.Direction == DirectionResponse {
+				db.Response = append(db.Response, record)
+			} else {
+				db.Request = append(db.Request, record)
+			}
+
+		default:
+			return nil, parser.lineError(newTokenError("invalid key", key, 0), lineNumber)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// parseLinkLine parses line of [mtu] section, labels are free text
+func (parser *Parser) parseLinkLine(db *Database, key string, value string, label *string, lineNumber int) error {
+
+	switch key {
+	case "label":
+		if value == "" {
+			return parser.lineError(newTokenError("invalid label", value, 0), lineNumber)
+		}
+		*label = value
+
+	case "sig":
+		if *label == "" {
+			return parser.lineError(newTokenError("MTU without label", value, 0), lineNumber)
+		}
+
+		mtu, err := parseMTU(value)
+		if err != nil {
+			return parser.lineError(err, lineNumber)
+		}
+
+		db.Links = append(db.Links, &Link{Label: *label, MTU: mtu, Line: lineNumber})
+
+	default:
+		return parser.lineError(newTokenError("invalid key", key, 0), lineNumber)
+	}
+
+	return nil
+}
+
+// ParseLabel parses label in "type:class:name:flavor" format
+func (parser *Parser) ParseLabel(s string) (*Label, error) {
+
+	ss := strings.SplitN(s, ":", 4)
+	if len(ss) != 4 || ss[1] == "" || ss[2] == "" {
+		return nil, newTokenError("invalid label", s, 0)
+	}
+
+	label := &Label{
+		Class:  ss[1],
+		Name:   ss[2],
+		Flavor: ss[3],
+	}
+
+	switch ss[0] {
+	case labelTypeSpecific:
+	case labelTypeGeneric:
+		label.Generic = true
+	default:
+		return nil, newTokenError("invalid label type", ss[0], 0)
+	}
+
+	return label, nil
+}
+
+func (parser *Parser) lineError(err error, line int) error {
+	if parseErr, ok := err.(*ParseError); ok {
+		parseErr.Line = line
+		return parseErr
+	}
+	return fmt.Errorf("line %d: %w", line, err)
+}
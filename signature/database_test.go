@@ -0,0 +1,92 @@
+package signature
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const testDatabase = `
+; comment
+classes = win,unix,other
+
+[mtu]
+
+label = Ethernet or modem
+sig   = 576
+sig   = 1500
+
+[tcp:request]
+
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+sig   = *:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:win:Windows:7 or 8
+sig   = *:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0
+
+[tcp:response]
+
+label = g:unix:Linux:2.4-2.6
+sig   = *:64:0:*:mss*4,0:mss:df:0
+
+[http:request]
+
+label = s:!:Chrome:11 or newer
+sys   = Windows,@unix
+sig   = 1:Host,Connection=[keep-alive],Accept=[*/*]::Chrome/
+`
+
+func TestParseDatabase(t *testing.T) {
+
+	p := Parser{}
+
+	db, err := p.ParseDatabase(strings.NewReader(testDatabase))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"win", "unix", "other"}, db.Classes)
+	assert.Len(t, db.Request, 3)
+	assert.Len(t, db.Response, 1)
+	assert.Equal(t, db.Response, db.Records(DirectionResponse))
+
+	r := db.Request[1]
+	assert.Equal(t, &Label{Class: "unix", Name: "Linux", Flavor: "3.11 and newer"}, r.Label)
+	assert.Equal(t, "s:unix:Linux:3.11 and newer", r.Label.String())
+	assert.Equal(t, DirectionRequest, r.Direction)
+	assert.Equal(t, "*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0", r.Raw)
+	assert.Equal(t, 15, r.Line)
+	assert.Equal(t, 7, r.Signature.WindowSize.WindowScalingFactor)
+
+	assert.True(t, db.Response[0].Label.Generic)
+	assert.Equal(t, "g:unix:Linux:2.4-2.6", db.Response[0].Label.String())
+}
+
+func TestParseDatabaseError(t *testing.T) {
+
+	var testData = []struct {
+		db    string
+		line  int
+		field string
+	}{
+		{"[tcp:request]\nsig = *:64:0:*:mss*20,10:mss:df:0", 2, ""},
+		{"[tcp:request]\nlabel = s:unix:Linux:3.11\n\nsig = *:64:0:*:mss*20,10:mss:X:0", 4, FieldQuirks},
+		{"[tcp:request]\nlabel = x:unix:Linux:3.11", 2, ""},
+		{"[tcp:request]\nlabel = s:unix", 2, ""},
+		{"[tcp:request]\nlabel = s:unix:Linux:\nxxx = 1", 3, ""},
+		{"[tcp:request]\nlabel", 2, ""},
+		{"[tcp:request", 1, ""},
+		{"[tcp:xxx]", 1, ""},
+	}
+
+	p := Parser{}
+	for _, item := range testData {
+		db, err := p.ParseDatabase(strings.NewReader(item.db))
+		assert.Nil(t, db)
+
+		var parseErr *ParseError
+		if assert.ErrorAs(t, err, &parseErr, item.db) {
+			assert.Equal(t, item.line, parseErr.Line, item.db)
+			assert.Equal(t, item.field, parseErr.Field, item.db)
+		}
+	}
+}
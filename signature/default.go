@@ -0,0 +1,100 @@
+package signature
+
+import (
+	_ "embed"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//go:embed p0f.fp
+var defaultDatabaseSource string
+
+var (
+	defaultDatabaseOnce sync.Once
+	defaultDatabase     *Database
+)
+
+// DefaultDatabase returns TCP and MTU signatures of p0f 3.09b embedded in the module (see p0f.fp),
+// they are parsed on the first call. Every call returns a deep copy of the database,
+// so records, labels, signatures and links may be modified by the caller.
+func DefaultDatabase() *Database {
+
+	defaultDatabaseOnce.Do(func() {
+		parser := Parser{}
+		db, err := parser.ParseDatabase(strings.NewReader(defaultDatabaseSource))
+		if err != nil {
+			panic("embedded p0f.fp is invalid: " + err.Error())
+		}
+		defaultDatabase = db
+	})
+
+	return defaultDatabase.copy()
+}
+
+// copy returns a deep copy of the database, records of the same label share the copy of the label
+func (db *Database) copy() *Database {
+
+	result := &Database{Classes: slices.Clone(db.Classes)}
+	labels := make(map[*Label]*Label)
+
+	copyRecords := func(records []*Record) []*Record {
+		copied := make([]*Record, len(records))
+		for i, record := range records {
+			r := *record
+			if record.Label != nil {
+				label, found := labels[record.Label]
+				if !found {
+					l := *record.Label
+					label = &l
+					labels[record.Label] = label
+				}
+				r.Label = label
+			}
+			if record.Signature != nil {
+				r.Signature = record.Signature.copy()
+			}
+			r.Sys = slices.Clone(record.Sys)
+			copied[i] = &r
+		}
+		return copied
+	}
+
+	result.Request = copyRecords(db.Request)
+	result.Response = copyRecords(db.Response)
+	for _, link := range db.Links {
+		l := *link
+		result.Links = append(result.Links, &l)
+	}
+
+	return result
+}
+
+// copy returns a deep copy of the signature
+func (s *Signature) copy() *Signature {
+
+	result := *s
+	result.OptionsLayout = slices.Clone(s.OptionsLayout)
+	if s.WindowSize != nil {
+		windowSize := *s.WindowSize
+		result.WindowSize = &windowSize
+	}
+	if s.Quirks != nil {
+		quirks := *s.Quirks
+		result.Quirks = &quirks
+	}
+
+	return &result
+}
+
+// LoadDatabaseWithDefault reads p0f.fp file and merges it with the embedded database,
+// signatures of the file go first, so they take precedence over the embedded ones
+func (parser *Parser) LoadDatabaseWithDefault(path string) (*Database, error) {
+
+	db, err := parser.LoadDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.Merge(DefaultDatabase()), nil
+}
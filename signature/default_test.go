@@ -0,0 +1,69 @@
+package signature
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultDatabase(t *testing.T) {
+
+	db := DefaultDatabase()
+	assert.NotEmpty(t, db.Request)
+	assert.NotEmpty(t, db.Response)
+	assert.Equal(t, []string{"win", "unix", "other"}, db.Classes)
+	assert.Equal(t, "s:unix:Linux:3.11 and newer", db.Request[0].Label.String())
+
+	// signatures of the database are in canonical form
+	for _, record := range db.Request {
+		assert.Equal(t, record.Raw, record.Signature.String())
+	}
+	assert.Empty(t, db.Duplicates())
+
+	// random TTL of userspace tools
+	var nmap *Record
+	for _, record := range db.Request {
+		if record.Label.Name == "NMap" {
+			nmap = record
+			break
+		}
+	}
+	if assert.NotNil(t, nmap) {
+		assert.True(t, nmap.Signature.RandomTTL)
+		assert.Equal(t, 64, nmap.Signature.InitialTTL)
+	}
+
+	// every call returns a deep copy
+	db.Request[0].Label.Name = "changed"
+	db.Request[0].Signature.WindowSize.WindowSize = 1
+	db.Request[0].Signature.Quirks.DF = false
+	db.Request[0].Signature.OptionsLayout[0] = 0
+	db.Links[0].MTU = 1
+	db.Request = db.Request[:1]
+	other := DefaultDatabase()
+	assert.Greater(t, len(other.Request), 1)
+	assert.Equal(t, "s:unix:Linux:3.11 and newer", other.Request[0].Label.String())
+	assert.Equal(t, other.Request[0].Raw, other.Request[0].Signature.String())
+	assert.NotEqual(t, 1, other.Links[0].MTU)
+	assert.Same(t, other.Request[0].Label, other.Request[1].Label)
+}
+
+func TestLoadDatabaseWithDefault(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "p0f.fp")
+	err := os.WriteFile(path, []byte(testDatabase), 0644)
+	assert.NoError(t, err)
+
+	p := Parser{}
+	db, err := p.LoadDatabaseWithDefault(path)
+	assert.NoError(t, err)
+	assert.Len(t, db.Request, 3+len(DefaultDatabase().Request))
+	assert.Equal(t, "*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0", db.Request[0].Raw)
+	assert.Equal(t, 14, db.Request[0].Line)
+	assert.Equal(t, path, db.Request[0].File)
+	assert.Empty(t, db.Request[len(db.Request)-1].File)
+
+	_, err = p.LoadDatabaseWithDefault(filepath.Join(t.TempDir(), "missing.fp"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package signature
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// signatureDocument is JSON and YAML representation of the signature,
+// fields are named and written as in p0f signature format, options have names of OptionName
+type signatureDocument struct {
+	IpVersion          string   `json:"ver" yaml:"ver"`
+	InitialTTL         string   `json:"ittl" yaml:"ittl"`
+	OptionLength       string   `json:"olen" yaml:"olen"`
+	MaximumSegmentSize string   `json:"mss" yaml:"mss"`
+	WindowSize         string   `json:"wsize" yaml:"wsize"`
+	WindowScale        string   `json:"scale" yaml:"scale"`
+	OptionsLayout      []string `json:"olayout" yaml:"olayout,flow"`
+	Quirks             []string `json:"quirks" yaml:"quirks,flow"`
+	PayloadSize        string   `json:"pclass" yaml:"pclass"`
+}
+
+// windowSizeDocument is JSON and YAML representation of the window size
+type windowSizeDocument struct {
+	WindowSize  string `json:"wsize" yaml:"wsize"`
+	WindowScale string `json:"scale" yaml:"scale"`
+}
+
+func (s *Signature) document() *signatureDocument {
+
+	window := windowSizeDocument{}
+	if s.WindowSize != nil {
+		window = *s.WindowSize.document()
+	}
+
+	options := make([]string, 0)
+	if layout := formatOptions(s.OptionsLayout, OptionName); layout != "" {
+		options = strings.Split(layout, ",")
+	}
+
+	return &signatureDocument{
+		IpVersion:          string(s.IpVersion),
+		InitialTTL:         formatInitialTTL(s.InitialTTL, s.RandomTTL),
+		OptionLength:       formatWildcard(s.OptionLength, OptionLengthWildcardIntValue),
+		MaximumSegmentSize: formatWildcard(s.MaximumSegmentSize, MaximumSegmentSizeWildcardIntValue),
+		WindowSize:         window.WindowSize,
+		WindowScale:        window.WindowScale,
+		OptionsLayout:      options,
+		Quirks:             append(make([]string, 0), QuirkNames(s.Quirks)...),
+		PayloadSize:        string(s.PayloadSize),
+	}
+}
+
+func (s *Signature) fromDocument(doc *signatureDocument) error {
+
+	parser := Parser{}
+	sig, err := parser.Parse(strings.Join([]string{
+		doc.IpVersion,
+		doc.InitialTTL,
+		doc.OptionLength,
+		doc.MaximumSegmentSize,
+		doc.WindowSize + "," + doc.WindowScale,
+		strings.Join(doc.OptionsLayout, ","),
+		strings.Join(doc.Quirks, ","),
+		doc.PayloadSize,
+	}, ":"))
+
+	if err != nil {
+		return err
+	}
+
+	*s = *sig
+	return nil
+}
+
+// marshalers have value receivers, so values are encoded as well as pointers
+
+func (s Signature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.document())
+}
+
+func (s *Signature) UnmarshalJSON(data []byte) error {
+	doc := signatureDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return s.fromDocument(&doc)
+}
+
+func (s Signature) MarshalYAML() (interface{}, error) {
+	return s.document(), nil
+}
+
+func (s *Signature) UnmarshalYAML(value *yaml.Node) error {
+	doc := signatureDocument{}
+	if err := value.Decode(&doc); err != nil {
+		return err
+	}
+	return s.fromDocument(&doc)
+}
+
+func (ws *WindowSize) document() *windowSizeDocument {
+	size, scale, _ := strings.Cut(formatWindowSize(ws), ",")
+	return &windowSizeDocument{WindowSize: size, WindowScale: scale}
+}
+
+func (ws *WindowSize) fromDocument(doc *windowSizeDocument) error {
+
+	parser := Parser{}
+	result, err := parser.parseWindowSize(doc.WindowSize + "," + doc.WindowScale)
+	if err != nil {
+		return err
+	}
+
+	*ws = *result
+	return nil
+}
+
+func (ws WindowSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ws.document())
+}
+
+func (ws *WindowSize) UnmarshalJSON(data []byte) error {
+	doc := windowSizeDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return ws.fromDocument(&doc)
+}
+
+func (ws WindowSize) MarshalYAML() (interface{}, error) {
+	return ws.document(), nil
+}
+
+func (ws *WindowSize) UnmarshalYAML(value *yaml.Node) error {
+	doc := windowSizeDocument{}
+	if err := value.Decode(&doc); err != nil {
+		return err
+	}
+	return ws.fromDocument(&doc)
+}
+
+// quirks are written as list of names in the order of p0f documentation
+
+func (q *QuirkFlags) fromNames(names []string) error {
+
+	parser := Parser{}
+	result, err := parser.parseQuirks(strings.Join(names, ","))
+	if err != nil {
+		return err
+	}
+
+	*q = QuirkFlags{}
+	if result != nil {
+		*q = *result
+	}
+	return nil
+}
+
+func (q QuirkFlags) MarshalJSON() ([]byte, error) {
+	return json.Marshal(append(make([]string, 0), QuirkNames(&q)...))
+}
+
+func (q *QuirkFlags) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	return q.fromNames(names)
+}
+
+func (q QuirkFlags) MarshalYAML() (interface{}, error) {
+	return append(make([]string, 0), QuirkNames(&q)...), nil
+}
+
+func (q *QuirkFlags) UnmarshalYAML(value *yaml.Node) error {
+	var names []string
+	if err := value.Decode(&names); err != nil {
+		return err
+	}
+	return q.fromNames(names)
+}
+
+// labels are written as in p0f.fp, "s:win:Windows:7 or 8"
+
+func (l Label) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Label) UnmarshalText(text []byte) error {
+
+	parser := Parser{}
+	label, err := parser.ParseLabel(string(text))
+	if err != nil {
+		return err
+	}
+
+	*l = *label
+	return nil
+}
@@ -0,0 +1,116 @@
+package signature
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestSignatureJSON(t *testing.T) {
+
+	p := Parser{}
+	sig, err := p.Parse("*:64-:0:*:mss*20,*:mss,sok,ts,nop,ws,?34,mptcp,eol+1:id+,df,ecn:+")
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(sig)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"ver": "*", "ittl": "64-", "olen": "0", "mss": "*", "wsize": "mss*20", "scale": "*",
+		"olayout": ["mss", "sok", "ts", "nop", "ws", "tfo", "mptcp", "eol+1"],
+		"quirks": ["df", "id+", "ecn"],
+		"pclass": "+"
+	}`, string(data))
+
+	decoded := &Signature{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, sig, decoded)
+
+	// values are encoded as pointers
+	value, err := json.Marshal(*sig)
+	assert.NoError(t, err)
+	assert.Equal(t, data, value)
+
+	value, err = json.Marshal(struct{ Signature Signature }{*sig})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Signature":`+string(data)+`}`, string(value))
+
+	value, err = yaml.Marshal(*sig)
+	assert.NoError(t, err)
+	assert.Contains(t, string(value), "olayout: [mss, sok, ts, nop, ws, tfo, mptcp, eol+1]")
+
+	// no options and quirks
+	sig, err = p.Parse("4:64:0:1460:16384,0:::0")
+	assert.NoError(t, err)
+
+	data, err = json.Marshal(sig)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"olayout":[],"quirks":[]`)
+
+	decoded = &Signature{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, sig, decoded)
+
+	// errors of the parser are returned
+	err = json.Unmarshal([]byte(`{"ver":"*","ittl":"64","olen":"0","mss":"*","wsize":"*","scale":"0","olayout":["xxx"],"quirks":[],"pclass":"0"}`), decoded)
+	var parseErr *ParseError
+	if assert.ErrorAs(t, err, &parseErr) {
+		assert.Equal(t, FieldOptions, parseErr.Field)
+		assert.Equal(t, "xxx", parseErr.Token)
+	}
+
+	// window size and quirks on their own
+	data, err = json.Marshal(&WindowSize{WindowSize: 8192, WindowSizeType: WindowTypeMod, WindowScalingFactor: 2})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"wsize":"%8192","scale":"2"}`, string(data))
+
+	ws := &WindowSize{}
+	assert.NoError(t, json.Unmarshal(data, ws))
+	assert.Equal(t, &WindowSize{WindowSize: 8192, WindowSizeType: WindowTypeMod, WindowScalingFactor: 2}, ws)
+
+	data, err = json.Marshal(&QuirkFlags{TsMinus: true, DF: true})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `["df","ts1-"]`, string(data))
+
+	quirks := &QuirkFlags{Bad: true}
+	assert.NoError(t, json.Unmarshal(data, quirks))
+	assert.Equal(t, &QuirkFlags{TsMinus: true, DF: true}, quirks)
+	assert.Error(t, json.Unmarshal([]byte(`["xxx"]`), quirks))
+}
+
+func TestDatabaseEncoding(t *testing.T) {
+
+	db := DefaultDatabase()
+
+	data, err := json.Marshal(db)
+	assert.NoError(t, err)
+
+	decoded := &Database{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, db, decoded)
+
+	data, err = yaml.Marshal(db)
+	assert.NoError(t, err)
+
+	decoded = &Database{}
+	assert.NoError(t, yaml.Unmarshal(data, decoded))
+	assert.Equal(t, db, decoded)
+
+	data, err = yaml.Marshal(db.Request[0])
+	assert.NoError(t, err)
+	assert.Equal(t, `label: s:unix:Linux:3.11 and newer
+direction: request
+raw: '*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0'
+sig:
+    ver: '*'
+    ittl: "64"
+    olen: "0"
+    mss: '*'
+    wsize: mss*20
+    scale: "10"
+    olayout: [mss, sok, ts, nop, ws]
+    quirks: [df, id+]
+    pclass: "0"
+line: 96
+`, string(data))
+}
@@ -0,0 +1,79 @@
+package signature
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	FieldVersion     = "ver"
+	FieldInitialTTL  = "ittl"
+	FieldOptionsLen  = "olen"
+	FieldMSS         = "mss"
+	FieldWindowSize  = "wsize"
+	FieldOptions     = "olayout"
+	FieldQuirks      = "quirks"
+	FieldPayloadSize = "pclass"
+)
+
+// signature fields in the order they appear in "ver:ittl:olen:mss:wsize,scale:olayout:quirks:pclass"
+var fieldNames = []string{
+	FieldVersion,
+	FieldInitialTTL,
+	FieldOptionsLen,
+	FieldMSS,
+	FieldWindowSize,
+	FieldOptions,
+	FieldQuirks,
+	FieldPayloadSize,
+}
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ParseError describes a signature that can not be parsed.
+// It can be inspected with errors.As to find out which part of the signature is wrong.
+type ParseError struct {
+	// Line in the source file, zero when the signature is not read from a file
+	Line int
+	// Field name as used in p0f.fp documentation ("ver", "ittl", "olayout", ...),
+	// empty when the signature can not be split into fields
+	Field string
+	// Zero based index of the field within the signature, -1 when unknown
+	Index int
+	// One based position of the offending token within the signature string
+	Column int
+	// Offending token, e.g. a single option name of the options layout
+	Token string
+	// Human-readable reason
+	Message string
+}
+
+func (e *ParseError) Error() string {
+
+	msg := fmt.Sprintf("%s '%s'", e.Message, e.Token)
+
+	if e.Field != "" {
+		msg = fmt.Sprintf("%s: %s (field %d, column %d)", e.Field, msg, e.Index+1, e.Column)
+	}
+
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrInvalidSignature
+}
+
+// newTokenError creates error for the token found at the given zero based offset of a field,
+// field related details are filled later by Parser.Parse
+func newTokenError(message string, token string, offset int) *ParseError {
+	return &ParseError{
+		Index:   -1,
+		Column:  offset + 1,
+		Token:   token,
+		Message: message,
+	}
+}
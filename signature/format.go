@@ -0,0 +1,156 @@
+package signature
+
+import (
+	"github.com/google/gopacket/layers"
+	"strconv"
+	"strings"
+)
+
+// String returns signature in p0f format. The form is canonical: quirks follow the order
+// of p0f documentation and options are written with p0f names, so signatures which differ
+// only in notation ("ecn,df" and "df,ecn", "tfo" and "?34") have the same string and p0f reads it.
+func (s *Signature) String() string {
+
+	fields := []string{
+		string(s.IpVersion),
+		formatInitialTTL(s.InitialTTL, s.RandomTTL),
+		formatWildcard(s.OptionLength, OptionLengthWildcardIntValue),
+		formatWildcard(s.MaximumSegmentSize, MaximumSegmentSizeWildcardIntValue),
+		formatWindowSize(s.WindowSize),
+		formatOptions(s.OptionsLayout, p0fOptionName),
+		formatQuirks(s.Quirks),
+		string(s.PayloadSize),
+	}
+
+	return strings.Join(fields, ":")
+}
+
+func formatInitialTTL(ttl int, random bool) string {
+	if random {
+		return strconv.Itoa(ttl) + "-"
+	}
+	return strconv.Itoa(ttl)
+}
+
+func formatWildcard(n int, wildcard int) string {
+	if n == wildcard {
+		return "*"
+	}
+	return strconv.Itoa(n)
+}
+
+func formatWindowSize(ws *WindowSize) string {
+
+	if ws == nil {
+		return "*,*"
+	}
+
+	var size string
+	value := strconv.Itoa(int(ws.WindowSize))
+
+	switch ws.WindowSizeType {
+	case WindowTypeAny:
+		size = "*"
+	case WindowTypeMod:
+		size = "%" + value
+	case WindowTypeMSS:
+		size = optionNameMSS + "*" + value
+	case WindowTypeMTU:
+		size = "mtu*" + value
+	default:
+		size = value
+	}
+
+	return size + "," + formatWildcard(ws.WindowScalingFactor, WindowScaleFactorWildcardIntValue)
+}
+
+// formatOptions writes options layout with option names, EOL followed by n more EOL entries is "eol+n"
+func formatOptions(layout []layers.TCPOptionKind, name func(option layers.TCPOptionKind) string) string {
+
+	options := make([]string, 0, len(layout))
+
+	for i, option := range layout {
+		if option == layers.TCPOptionKindEndList {
+			options = append(options, optionNameEndList+"+"+strconv.Itoa(len(layout)-i-1))
+			break
+		}
+		options = append(options, name(option))
+	}
+
+	return strings.Join(options, ",")
+}
+
+// OptionName returns name of TCP option, "tfo" and "mptcp" for options p0f reports as "?34" and "?30",
+// "?n" for options without name. Parser accepts both forms.
+func OptionName(option layers.TCPOptionKind) string {
+	switch option {
+	case TCPOptionKindMPTCP:
+		return optionNameMPTCP
+	case TCPOptionKindFastOpen:
+		return optionNameFastOpen
+	}
+	return p0fOptionName(option)
+}
+
+// p0fOptionName returns p0f name of TCP option, "?n" for options without name
+func p0fOptionName(option layers.TCPOptionKind) string {
+	switch option {
+	case layers.TCPOptionKindEndList:
+		return optionNameEndList
+	case layers.TCPOptionKindNop:
+		return optionNameNop
+	case layers.TCPOptionKindMSS:
+		return optionNameMSS
+	case layers.TCPOptionKindWindowScale:
+		return optionNameWindowScale
+	case layers.TCPOptionKindSACKPermitted:
+		return optionNameSACKPermitted
+	case layers.TCPOptionKindSACK:
+		return optionNameSACK
+	case layers.TCPOptionKindTimestamps:
+		return optionNameTimestamps
+	}
+	return optionNameUnknown + strconv.Itoa(int(option))
+}
+
+// QuirkNames returns names of quirks set in the order of p0f documentation
+func QuirkNames(q *QuirkFlags) []string {
+
+	if q == nil {
+		return nil
+	}
+
+	var names []string
+	for _, quirk := range []struct {
+		set  bool
+		name string
+	}{
+		{q.DF, quirkDF},
+		{q.IdPlus, quirkIdPlus},
+		{q.IdMinus, quirkIdMinus},
+		{q.ECN, quirkECN},
+		{q.ZeroPlus, quirkZeroPlus},
+		{q.Flow, quirkFlow},
+		{q.SeqMinus, quirkSeqMinus},
+		{q.AckPlus, quirkAckPlus},
+		{q.AckMinus, quirkAckMinus},
+		{q.UptrPlus, quirkUptrPlus},
+		{q.UrgfPlus, quirkUrgfPlus},
+		{q.PushfPlus, quirkPushfPlus},
+		{q.TsMinus, quirkTsMinus},
+		{q.TsPlus, quirkTsPlus},
+		{q.OptPlus, quirkOptPlus},
+		{q.EXWS, quirkEXWS},
+		{q.Bad, quirkBad},
+	} {
+		if quirk.set {
+			names = append(names, quirk.name)
+		}
+	}
+
+	return names
+}
+
+func formatQuirks(q *QuirkFlags) string {
+	return strings.Join(QuirkNames(q), ",")
+}
@@ -0,0 +1,157 @@
+package signature
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	FieldWindowScale = "scale"
+
+	// maximum distance in hops between the host and observer, same as in p0f
+	MaxDistance = 35
+)
+
+// Mismatch is a field of the observed signature which does not match the reference signature
+type Mismatch struct {
+	// field name as in p0f format, see Field* constants
+	Field    string
+	Expected string
+	Observed string
+}
+
+func (m Mismatch) String() string {
+	return m.Field + ": expected '" + m.Expected + "', observed '" + m.Observed + "'"
+}
+
+// Compare checks the observed signature of a packet against the reference signature,
+// e.g. of the database, and returns fields which do not match. Observed signature has
+// exact values and no wildcards: version of IP, TTL of the packet instead of initial TTL,
+// MSS (zero if not set), window size of WindowTypeNormal type and window scale (zero if not set).
+//
+// Quirks are compared exactly, the fuzzy matching of p0f ("df" and "id+" disappearing,
+// "id-" and "ecn" appearing) is not applied. Quirks of another IP version are ignored.
+func (s *Signature) Compare(observed *Signature) []Mismatch {
+
+	var result []Mismatch
+	mismatch := func(field string, expected string, observed string) {
+		result = append(result, Mismatch{Field: field, Expected: expected, Observed: observed})
+	}
+
+	if s.IpVersion != IpVersionAny && s.IpVersion != observed.IpVersion {
+		mismatch(FieldVersion, string(s.IpVersion), string(observed.IpVersion))
+	}
+
+	// TTL decreases on every hop, userspace tools with random TTLs are limited only by maximum
+	ttl := observed.InitialTTL
+	if ttl > s.InitialTTL || (!s.RandomTTL && s.InitialTTL-ttl > MaxDistance) {
+		mismatch(FieldInitialTTL, formatInitialTTL(s.InitialTTL, s.RandomTTL), strconv.Itoa(ttl))
+	}
+
+	if s.OptionLength != OptionLengthWildcardIntValue && s.OptionLength != observed.OptionLength {
+		mismatch(FieldOptionsLen, strconv.Itoa(s.OptionLength), strconv.Itoa(observed.OptionLength))
+	}
+
+	if s.MaximumSegmentSize != MaximumSegmentSizeWildcardIntValue && s.MaximumSegmentSize != observed.MaximumSegmentSize {
+		mismatch(FieldMSS, strconv.Itoa(s.MaximumSegmentSize), strconv.Itoa(observed.MaximumSegmentSize))
+	}
+
+	if s.WindowSize != nil && observed.WindowSize != nil {
+		window := int(observed.WindowSize.WindowSize)
+		size, scale, _ := strings.Cut(formatWindowSize(s.WindowSize), ",")
+
+		if !s.matchWindowSize(window, observed) {
+			mismatch(FieldWindowSize, size, strconv.Itoa(window))
+		}
+
+		factor := s.WindowSize.WindowScalingFactor
+		if factor != WindowScaleFactorWildcardIntValue && factor != observed.WindowSize.WindowScalingFactor {
+			mismatch(FieldWindowScale, scale, strconv.Itoa(observed.WindowSize.WindowScalingFactor))
+		}
+	}
+
+	if layout, observedLayout := formatOptions(s.OptionsLayout, OptionName), formatOptions(observed.OptionsLayout, OptionName); layout != observedLayout {
+		mismatch(FieldOptions, layout, observedLayout)
+	}
+
+	if quirks, observedQuirks := s.quirksOf(observed.IpVersion), observed.quirksOf(observed.IpVersion); quirks != observedQuirks {
+		mismatch(FieldQuirks, quirks, observedQuirks)
+	}
+
+	if s.PayloadSize != PayloadSizeAny && s.PayloadSize != observed.PayloadSize {
+		mismatch(FieldPayloadSize, string(s.PayloadSize), string(observed.PayloadSize))
+	}
+
+	return result
+}
+
+// Matches reports whether the observed signature matches the reference signature, see Compare
+func (s *Signature) Matches(observed *Signature) bool {
+	return len(s.Compare(observed)) == 0
+}
+
+// Match returns the first record of the direction matching the observed signature, specific records
+// take precedence over generic ones as in p0f. Nil is returned if there is no match.
+func (db *Database) Match(observed *Signature, direction Direction) *Record {
+
+	var generic *Record
+	for _, record := range db.Records(direction) {
+		if !record.Signature.Matches(observed) {
+			continue
+		}
+		if !record.Label.Generic {
+			return record
+		}
+		if generic == nil {
+			generic = record
+		}
+	}
+
+	return generic
+}
+
+func (s *Signature) matchWindowSize(window int, observed *Signature) bool {
+
+	switch s.WindowSize.WindowSizeType {
+	case WindowTypeNormal:
+		return window == int(s.WindowSize.WindowSize)
+	case WindowTypeMod:
+		return window%int(s.WindowSize.WindowSize) == 0
+	case WindowTypeMSS:
+		return window == observed.MaximumSegmentSize*int(s.WindowSize.WindowSize)
+	case WindowTypeMTU:
+		return window == MTU(observed.MaximumSegmentSize, observed.IpVersion)*int(s.WindowSize.WindowSize)
+	}
+
+	// WindowTypeAny
+	return true
+}
+
+// quirksOf returns canonical quirks string without quirks not applicable to IP version
+func (s *Signature) quirksOf(version IpVersion) string {
+
+	if s.Quirks == nil {
+		return ""
+	}
+
+	quirks := *s.Quirks
+	switch version {
+	case IpVersion4:
+		quirks.Flow = false
+	case IpVersion6:
+		quirks.DF = false
+		quirks.IdPlus = false
+		quirks.IdMinus = false
+		quirks.ZeroPlus = false
+	}
+
+	return formatQuirks(&quirks)
+}
+
+// MTU returns MTU for MSS, that is MSS plus size of IP and TCP headers without options
+func MTU(mss int, version IpVersion) int {
+	if version == IpVersion6 {
+		return mss + 60
+	}
+	return mss + 40
+}
@@ -0,0 +1,83 @@
+package signature
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestSignatureCompare(t *testing.T) {
+
+	parser := Parser{}
+
+	var testData = []struct {
+		reference string
+		observed  string
+		fields    []string
+	}{
+		{"4:64:0:*:mss*10,0:mss:df,id+:0", "4:64:0:1460:14600,0:mss:df,id+:0", nil},
+		{"4:64:0:*:mss*10,0:mss:df,id+:0", "4:40:0:1460:14600,0:mss:df,id+:0", nil},
+		{"4:64:0:*:mss*10,0:mss:df,id+:0", "4:28:0:1460:14600,0:mss:df,id+:0", []string{FieldInitialTTL}},
+		{"4:64-:0:*:mss*10,0:mss:df,id+:0", "4:28:0:1460:14600,0:mss:df,id+:0", nil},
+		{"4:64:0:*:mss*10,0:mss:df,id+:0", "4:65:0:1460:14600,0:mss:df,id+:0", []string{FieldInitialTTL}},
+		{"4:64:0:*:mss*10,0:mss:df,id+:0", "4:64:0:1460:14601,0:mss:df,id+:0", []string{FieldWindowSize}},
+		{"*:128:0:*:mtu*2,*:mss:df,id+:0", "4:128:0:1460:3000,8:mss:df,id+:0", nil},
+		{"*:128:0:*:%8192,8:mss:df,id+:0", "4:128:0:1460:16384,8:mss:df,id+:0", nil},
+		{"*:128:0:*:%8192,8:mss:df,id+:0", "4:128:0:1460:16385,7:mss:df,id+:0", []string{FieldWindowSize, FieldWindowScale}},
+		{"6:64:0:1460:*,0:mss:flow:0", "4:64:4:1400:1,0:mss,nop:df:+", []string{FieldVersion, FieldOptionsLen, FieldMSS, FieldOptions, FieldQuirks, FieldPayloadSize}},
+		{"*:64:*:*:*,*:mss::*", "4:64:4:1400:1,0:mss::+", nil},
+		{"*:64:0:*:*,*:mss,eol+1::0", "4:64:0:1400:1,0:mss,eol+1::0", nil},
+		{"*:64:0:*:*,*:mss,eol+1::0", "4:64:4:1400:1,0:mss,eol+2::0", []string{FieldOptionsLen, FieldOptions}},
+		// quirks of another IP version are ignored
+		{"*:64:0:*:*,*:mss:df,flow:0", "4:64:0:1400:1,0:mss:df:0", nil},
+		{"*:64:0:*:*,*:mss:df,flow:0", "6:64:0:1400:1,0:mss:flow:0", nil},
+	}
+
+	for _, item := range testData {
+		reference, err := parser.Parse(item.reference)
+		assert.Nil(t, err)
+		observed, err := parser.Parse(item.observed)
+		assert.Nil(t, err)
+
+		var fields []string
+		for _, mismatch := range reference.Compare(observed) {
+			fields = append(fields, mismatch.Field)
+		}
+
+		assert.Equal(t, item.fields, fields, item.reference)
+		assert.Equal(t, item.fields == nil, reference.Matches(observed))
+	}
+
+	reference, _ := parser.Parse("4:64:0:*:mss*10,0:mss:df,id+:0")
+	observed, _ := parser.Parse("4:64:0:1460:14600,0:mss:df:0")
+	assert.Equal(t, "quirks: expected 'df,id+', observed 'df'", reference.Compare(observed)[0].String())
+}
+
+func TestDatabaseMatch(t *testing.T) {
+
+	p := Parser{}
+	db, err := p.ParseDatabase(strings.NewReader(`
+[tcp:request]
+
+label = g:unix:Linux:generic
+sig   = *:64:0:*:*,*:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:unix:Linux:3.11 and newer
+sig   = *:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0
+
+label = s:unix:Linux:other
+sig   = *:64:0:*:mss*20,*:mss,sok,ts,nop,ws:df,id+:0
+`))
+	assert.NoError(t, err)
+
+	observed, _ := p.Parse("4:60:0:1460:29200,10:mss,sok,ts,nop,ws:df,id+:0")
+	assert.Equal(t, "s:unix:Linux:3.11 and newer", db.Match(observed, DirectionRequest).Label.String())
+
+	// generic match if there is no specific one
+	observed, _ = p.Parse("4:60:0:1460:1000,10:mss,sok,ts,nop,ws:df,id+:0")
+	assert.Equal(t, "g:unix:Linux:generic", db.Match(observed, DirectionRequest).Label.String())
+
+	assert.Nil(t, db.Match(observed, DirectionResponse))
+	observed, _ = p.Parse("4:60:0:1460:1000,10:mss:df,id+:0")
+	assert.Nil(t, db.Match(observed, DirectionRequest))
+}
@@ -0,0 +1,76 @@
+package signature
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Link is MTU of [mtu] section of the database, e.g. "DSL" for 1492.
+// MSS of the signature is used to guess the network hookup of the host.
+type Link struct {
+	Label string `json:"label" yaml:"label"`
+	MTU   int    `json:"mtu" yaml:"mtu"`
+	// line in the source file
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+// maximum MTU of [mtu] section, that is maximum size of IP packet
+const maxMTU = 0xFFFF
+
+// Link returns the first link of the MTU of the observed MSS, nil if the MTU is unknown.
+// MTU is MSS plus size of headers of the IP version, see MTU.
+func (db *Database) Link(mss int, version IpVersion) *Link {
+
+	mtu := MTU(mss, version)
+	for _, link := range db.Links {
+		if link.MTU == mtu {
+			return link
+		}
+	}
+
+	return nil
+}
+
+// LinkMSS returns MSS values of MTUs of the link label in order of the database,
+// labels are compared case-insensitively
+func (db *Database) LinkMSS(label string, version IpVersion) []int {
+
+	var result []int
+	for _, link := range db.Links {
+		if !strings.EqualFold(link.Label, label) {
+			continue
+		}
+		if mss := link.MTU - MTU(0, version); mss > 0 {
+			result = append(result, mss)
+		}
+	}
+
+	return result
+}
+
+// LinkLabels returns labels of links in order of the database without repeats
+func (db *Database) LinkLabels() []string {
+
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, link := range db.Links {
+		if !seen[link.Label] {
+			seen[link.Label] = true
+			result = append(result, link.Label)
+		}
+	}
+
+	return result
+}
+
+// parseMTU parses value of "sig" key of [mtu] section
+func parseMTU(s string) (int, error) {
+
+	mtu, err := strconv.Atoi(s)
+	if err != nil || mtu < 1 || mtu > maxMTU {
+		return 0, newTokenError("invalid MTU", s, 0)
+	}
+
+	return mtu, nil
+}
@@ -0,0 +1,70 @@
+package signature
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDatabaseLinks(t *testing.T) {
+
+	p := Parser{}
+
+	db, err := p.ParseDatabase(strings.NewReader(testDatabase))
+	assert.NoError(t, err)
+	assert.Equal(t, []*Link{
+		{Label: "Ethernet or modem", MTU: 576, Line: 8},
+		{Label: "Ethernet or modem", MTU: 1500, Line: 9},
+	}, db.Links)
+
+	assert.Equal(t, "Ethernet or modem", db.Link(1460, IpVersion4).Label)
+	assert.Equal(t, "Ethernet or modem", db.Link(1440, IpVersion6).Label)
+	assert.Nil(t, db.Link(1440, IpVersion4))
+	assert.Equal(t, []int{536, 1460}, db.LinkMSS("ethernet or MODEM", IpVersion4))
+	assert.Equal(t, []int{516, 1440}, db.LinkMSS("Ethernet or modem", IpVersion6))
+	assert.Empty(t, db.LinkMSS("DSL", IpVersion4))
+
+	// embedded database
+	db = DefaultDatabase()
+	assert.Equal(t, "DSL", db.Link(1452, IpVersion4).Label)
+	assert.Equal(t, "generic tunnel or VPN", db.Link(1380, IpVersion6).Label)
+	assert.Equal(t, "loopback", db.Link(16396, IpVersion4).Label)
+	assert.Contains(t, db.LinkLabels(), "IPSec or GRE")
+
+	// written links are read back the same
+	buf := bytes.Buffer{}
+	_, err = db.Dedup().WriteTo(&buf)
+	assert.NoError(t, err)
+
+	written, err := p.ParseDatabase(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, written.Links, len(db.Links))
+	assert.Equal(t, db.LinkLabels(), written.LinkLabels())
+}
+
+func TestDatabaseLinksError(t *testing.T) {
+
+	var testData = []struct {
+		db   string
+		line int
+	}{
+		{"[mtu]\nsig = 1500", 2},
+		{"[mtu]\nlabel = DSL\nsig = 0", 3},
+		{"[mtu]\nlabel = DSL\nsig = 1500x", 3},
+		{"[mtu]\nlabel = DSL\nsig = 65536", 3},
+		{"[mtu]\nlabel = DSL\nmtu = 1492", 3},
+		{"[mtu]\nlabel =", 2},
+	}
+
+	p := Parser{}
+	for _, item := range testData {
+		db, err := p.ParseDatabase(strings.NewReader(item.db))
+		assert.Nil(t, db)
+
+		var parseErr *ParseError
+		if assert.ErrorAs(t, err, &parseErr, item.db) {
+			assert.Equal(t, item.line, parseErr.Line, item.db)
+		}
+	}
+}
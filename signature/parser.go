@@ -1,7 +1,7 @@
 package signature
 
 import (
-	"fmt"
+	"errors"
 	"github.com/google/gopacket/layers"
 	"strconv"
 	"strings"
@@ -14,8 +14,8 @@ type Parser struct {
 func (parser *Parser) Parse(signature string) (*Signature, error) {
 
 	ss := strings.Split(signature, ":")
-	if len(ss) != 8 {
-		return nil, fmt.Errorf("invalid signature '%s'", signature)
+	if len(ss) != len(fieldNames) {
+		return nil, newTokenError("invalid signature", signature, 0)
 	}
 
 	var err error
@@ -23,45 +23,78 @@ func (parser *Parser) Parse(signature string) (*Signature, error) {
 
 	result.IpVersion, err = parser.parseIpVersion(ss[0])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 0)
 	}
 
-	result.InitialTTL, err = parser.parseInitialTTL(ss[1])
+	// userspace tools which generate random TTLs have "-" suffix after maximum initial TTL
+	ttl := ss[1]
+	if strings.HasSuffix(ttl, "-") {
+		result.RandomTTL = true
+		ttl = ttl[:len(ttl)-1]
+	}
+
+	result.InitialTTL, err = parser.parseInitialTTL(ttl)
+	if err != nil {
+		return nil, parser.fieldError(err, ss, 1)
+	}
+
+	result.OptionLength, err = parser.parseOptionLength(ss[2])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 2)
 	}
 
 	result.MaximumSegmentSize, err = parser.parseMaximumSegmentSize(ss[3])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 3)
 	}
 
 	result.WindowSize, err = parser.parseWindowSize(ss[4])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 4)
 	}
 
 	result.OptionsLayout, err = parser.parseOptions(ss[5])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 5)
 	}
 
 	result.Quirks, err = parser.parseQuirks(ss[6])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 6)
 	}
 
 	result.PayloadSize, err = parser.parsePayloadSize(ss[7])
 	if err != nil {
-		return nil, err
+		return nil, parser.fieldError(err, ss, 7)
 	}
 
 	return &result, nil
 }
 
+// fieldError fills field related details of the error returned by one of field parsers,
+// column is converted from the field offset to the offset within the whole signature
+func (parser *Parser) fieldError(err error, fields []string, index int) error {
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		return err
+	}
+
+	column := parseErr.Column
+	for _, field := range fields[:index] {
+		column += len(field) + 1
+	}
+
+	parseErr.Field = fieldNames[index]
+	parseErr.Index = index
+	parseErr.Column = column
+
+	return parseErr
+}
+
 func (parser *Parser) parseWindowSize(ws string) (*WindowSize, error) {
 
-	errorMessage := fmt.Errorf("invalid windows size format '%s'", ws)
+	errorMessage := newTokenError("invalid windows size format", ws, 0)
 	windowData := strings.Split(ws, ",")
 
 	if len(windowData) != 2 {
@@ -82,7 +115,7 @@ func (parser *Parser) parseWindowSize(ws string) (*WindowSize, error) {
 	} else {
 		n, err := strconv.Atoi(scale)
 		if err != nil {
-			return nil, errorMessage
+			return nil, newTokenError("invalid window scaling factor", scale, len(wsize)+1)
 		}
 		wScale = n
 	}
@@ -142,9 +175,25 @@ func (parser *Parser) parseWindowSize(ws string) (*WindowSize, error) {
 
 }
 
+func (parser *Parser) parseOptionLength(s string) (int, error) {
+
+	errorMsg := "invalid options length value"
+
+	if s == "*" {
+		return OptionLengthWildcardIntValue, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i > 0xFF {
+		return 0, newTokenError(errorMsg, s, 0)
+	}
+
+	return i, nil
+}
+
 func (parser *Parser) parseMaximumSegmentSize(s string) (int, error) {
 
-	errorMsg := "invalid maximum segment size value '%s'"
+	errorMsg := "invalid maximum segment size value"
 
 	if s == "*" {
 		return MaximumSegmentSizeWildcardIntValue, nil
@@ -152,11 +201,11 @@ func (parser *Parser) parseMaximumSegmentSize(s string) (int, error) {
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, fmt.Errorf(errorMsg, s)
+		return 0, newTokenError(errorMsg, s, 0)
 	}
 
 	if i < 0 || i > 0xFFFFF {
-		return 0, fmt.Errorf(errorMsg, s)
+		return 0, newTokenError(errorMsg, s, 0)
 	}
 
 	return i, nil
@@ -164,22 +213,22 @@ func (parser *Parser) parseMaximumSegmentSize(s string) (int, error) {
 
 func (parser *Parser) parseInitialTTL(s string) (int, error) {
 
-	errorMsg := "invalid initial TTL value '%s'"
+	errorMsg := "invalid initial TTL value"
 
 	if len(s) > 0 {
 		i, err := strconv.Atoi(s)
 		if err != nil {
-			return 0, fmt.Errorf(errorMsg, s)
+			return 0, newTokenError(errorMsg, s, 0)
 		}
 
 		if i <= 0 {
-			return 0, fmt.Errorf(errorMsg, s)
+			return 0, newTokenError(errorMsg, s, 0)
 		}
 
 		return i, nil
 	}
 
-	return 0, fmt.Errorf(errorMsg, s)
+	return 0, newTokenError(errorMsg, s, 0)
 }
 
 func (parser *Parser) parseQuirks(s string) (*QuirkFlags, error) {
@@ -190,6 +239,7 @@ func (parser *Parser) parseQuirks(s string) (*QuirkFlags, error) {
 
 	quirks := strings.Split(s, ",")
 
+	offset := 0
 	flags := QuirkFlags{}
 	for _, quirk := range quirks {
 		switch quirk {
@@ -228,8 +278,9 @@ func (parser *Parser) parseQuirks(s string) (*QuirkFlags, error) {
 		case quirkBad:
 			flags.Bad = true
 		default:
-			return nil, fmt.Errorf("invalid quirk '%s'", quirk)
+			return nil, newTokenError("invalid quirk", quirk, offset)
 		}
+		offset += len(quirk) + 1
 	}
 
 	return &flags, nil
@@ -246,7 +297,7 @@ func (parser *Parser) parsePayloadSize(s string) (PayloadSize, error) {
 		return PayloadSizeAny, nil
 	}
 
-	return "", fmt.Errorf("invalid payload size value '%s'", s)
+	return "", newTokenError("invalid payload size value", s, 0)
 }
 
 func (parser *Parser) parseIpVersion(s string) (IpVersion, error) {
@@ -258,7 +309,7 @@ func (parser *Parser) parseIpVersion(s string) (IpVersion, error) {
 	case "*":
 		return IpVersionAny, nil
 	}
-	return "", fmt.Errorf("invalid IP version '%s'", s)
+	return "", newTokenError("invalid IP version", s, 0)
 }
 
 func (parser *Parser) parseOptions(s string) ([]layers.TCPOptionKind, error) {
@@ -269,25 +320,45 @@ func (parser *Parser) parseOptions(s string) ([]layers.TCPOptionKind, error) {
 
 	opts := strings.Split(s, ",")
 
-	errorMsg := "invalid option '%s'"
+	errorMsg := "invalid option"
+	lengthErrorMsg := "options layout exceeds 40 bytes at option"
 	options := make([]layers.TCPOptionKind, 0)
 
+	offset := 0
+	length := 0
 	for _, opt := range opts {
 
-		if len(opt) >= 5 {
-			// explicit end of options, followed by n bytes of padding
-			if opt[:4] == (optionNameEndList + "+") {
-				i, err := strconv.Atoi(opt[4:])
-				if err != nil {
-					return nil, fmt.Errorf(errorMsg, opt)
-				}
+		// explicit end of options, followed by n bytes of padding
+		if strings.HasPrefix(opt, optionNameEndList+"+") {
+			i, err := strconv.Atoi(opt[len(optionNameEndList)+1:])
+			if err != nil || i < 0 {
+				return nil, newTokenError(errorMsg, opt, offset)
+			}
+			length += 1 + i
+			if length > MaxOptionsLength {
+				return nil, newTokenError(lengthErrorMsg, opt, offset)
+			}
+			options = append(options, layers.TCPOptionKindEndList)
+			for n := 1; n <= i; n++ {
 				options = append(options, layers.TCPOptionKindEndList)
-				for n := 1; n <= i; n++ {
-					options = append(options, layers.TCPOptionKindEndList)
-				}
-				continue
 			}
-			return nil, fmt.Errorf(errorMsg, opt)
+			offset += len(opt) + 1
+			continue
+		}
+
+		// unknown option ID n
+		if strings.HasPrefix(opt, optionNameUnknown) {
+			i, err := strconv.Atoi(opt[len(optionNameUnknown):])
+			if err != nil || i < 0 || i > 0xFF {
+				return nil, newTokenError(errorMsg, opt, offset)
+			}
+			options = append(options, layers.TCPOptionKind(i))
+			length += optionMinLength(layers.TCPOptionKind(i))
+			if length > MaxOptionsLength {
+				return nil, newTokenError(lengthErrorMsg, opt, offset)
+			}
+			offset += len(opt) + 1
+			continue
 		}
 
 		switch opt {
@@ -307,10 +378,43 @@ func (parser *Parser) parseOptions(s string) ([]layers.TCPOptionKind, error) {
 			options = append(options, layers.TCPOptionKindSACK)
 		case optionNameTimestamps:
 			options = append(options, layers.TCPOptionKindTimestamps)
+		case optionNameMPTCP:
+			// multipath TCP, same as "?30"
+			options = append(options, TCPOptionKindMPTCP)
+		case optionNameFastOpen:
+			// TCP Fast Open cookie, same as "?34"
+			options = append(options, TCPOptionKindFastOpen)
 		default:
-			return nil, fmt.Errorf(errorMsg, opt)
+			return nil, newTokenError(errorMsg, opt, offset)
 		}
+
+		length += optionMinLength(options[len(options)-1])
+		if length > MaxOptionsLength {
+			return nil, newTokenError(lengthErrorMsg, opt, offset)
+		}
+		offset += len(opt) + 1
 	}
 
 	return options, nil
 }
+
+// optionMinLength returns the smallest length of TCP option in bytes, options of variable length
+// have the data they are spoofed with on SYN: one SACK block, empty TFO cookie, MP_CAPABLE without key
+func optionMinLength(option layers.TCPOptionKind) int {
+	switch option {
+	case layers.TCPOptionKindEndList, layers.TCPOptionKindNop:
+		return 1
+	case layers.TCPOptionKindMSS:
+		return 4
+	case layers.TCPOptionKindWindowScale:
+		return 3
+	case layers.TCPOptionKindTimestamps:
+		return 10
+	case layers.TCPOptionKindSACK:
+		return 10
+	case TCPOptionKindMPTCP:
+		return 4
+	}
+	// kind and length
+	return 2
+}
@@ -16,6 +16,7 @@ func TestParse(t *testing.T) {
 		{":", true, nil},
 		{"X:::::::", true, nil},               // ver
 		{"*:X::::::", true, nil},              // ittl
+		{"*:64:X:::::", true, nil},            // olen
 		{"*:64:*:X::::", true, nil},           // mss
 		{"*:64:*:65535:X:::", true, nil},      // wsize
 		{"*:64:*:65535:*,0:X::", true, nil},   // olayout
@@ -24,6 +25,7 @@ func TestParse(t *testing.T) {
 		{"*:64:*:65535:*,0::df:0", false, &Signature{
 			IpVersion:          "*",
 			InitialTTL:         64,
+			OptionLength:       OptionLengthWildcardIntValue,
 			MaximumSegmentSize: 65535,
 			WindowSize: &WindowSize{
 				WindowSize:          0,
@@ -37,6 +39,15 @@ func TestParse(t *testing.T) {
 	}
 
 	p := Parser{}
+
+	r, err := p.Parse("*:64-:0:1460:1024,0:mss::0")
+	assert.NoError(t, err)
+	assert.Equal(t, 64, r.InitialTTL)
+	assert.True(t, r.RandomTTL)
+
+	_, err = p.Parse("*:-:0:1460:1024,0:mss::0")
+	assert.Error(t, err)
+
 	for _, item := range testData {
 		r, err := p.Parse(item.signature)
 		assert.Equal(t, item.result, r)
@@ -273,6 +284,25 @@ func TestParseOptions(t *testing.T) {
 	assert.Equal(t, []layers.TCPOptionKind{layers.TCPOptionKindEndList}, r)
 	assert.NoError(t, err)
 
+	r, err = p.parseOptions("mss,?30,mptcp,?34,tfo,?255")
+	assert.Equal(t, []layers.TCPOptionKind{
+		layers.TCPOptionKindMSS, TCPOptionKindMPTCP, TCPOptionKindMPTCP,
+		TCPOptionKindFastOpen, TCPOptionKindFastOpen, 255,
+	}, r)
+	assert.NoError(t, err)
+
+	r, err = p.parseOptions("?256")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptions("?")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptions("eol+-1")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
 	r, err = p.parseOptions("xxx")
 	assert.Nil(t, r)
 	assert.Error(t, err)
@@ -297,4 +327,116 @@ func TestParseOptions(t *testing.T) {
 	assert.Nil(t, r)
 	assert.NoError(t, err)
 
+	// TCP options are at most 40 bytes long
+	r, err = p.parseOptions("mss,eol+35")
+	assert.Len(t, r, 37)
+	assert.NoError(t, err)
+
+	r, err = p.parseOptions("mss,eol+36")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptions("eol+100000000")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptions("mss,sok,ts,nop,ws,ts,ts,nop")
+	assert.Nil(t, r)
+	assert.Error(t, err)
+
+}
+
+func TestParseError(t *testing.T) {
+
+	var testData = []struct {
+		signature string
+		field     string
+		index     int
+		column    int
+		token     string
+	}{
+		{":", "", -1, 1, ":"},
+		{"X:64:0:*:mss*20,10:mss:df:0", FieldVersion, 0, 1, "X"},
+		{"*:X:0:*:mss*20,10:mss:df:0", FieldInitialTTL, 1, 3, "X"},
+		{"*:64:0:X:mss*20,10:mss:df:0", FieldMSS, 3, 8, "X"},
+		{"*:64:0:*:mss*X,10:mss:df:0", FieldWindowSize, 4, 10, "mss*X,10"},
+		{"*:64:0:*:mss*20,X:mss:df:0", FieldWindowSize, 4, 17, "X"},
+		{"*:64:0:*:mss*20,10:mss,nop,X:df:0", FieldOptions, 5, 28, "X"},
+		{"*:64:0:*:8192,0:mss,eol+60:df:0", FieldOptions, 5, 21, "eol+60"},
+		{"*:64:0:*:mss*20,10:mss:df,id+,X:0", FieldQuirks, 6, 31, "X"},
+		{"*:64:0:*:mss*20,10:mss:df:X", FieldPayloadSize, 7, 27, "X"},
+	}
+
+	p := Parser{}
+	for _, item := range testData {
+		_, err := p.Parse(item.signature)
+
+		var parseErr *ParseError
+		if assert.ErrorAs(t, err, &parseErr) {
+			assert.Equal(t, item.field, parseErr.Field, item.signature)
+			assert.Equal(t, item.index, parseErr.Index, item.signature)
+			assert.Equal(t, item.column, parseErr.Column, item.signature)
+			assert.Equal(t, item.token, parseErr.Token, item.signature)
+			assert.Equal(t, item.token, item.signature[parseErr.Column-1:parseErr.Column-1+len(item.token)])
+		}
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	}
+
+	_, err := p.Parse("*:64:0:*:mss*20,10:mss,nop,X:df:0")
+	assert.EqualError(t, err, "olayout: invalid option 'X' (field 6, column 28)")
+}
+
+func TestParseOptionLength(t *testing.T) {
+	p := Parser{}
+
+	r, err := p.parseOptionLength("*")
+	assert.Equal(t, OptionLengthWildcardIntValue, r)
+	assert.NoError(t, err)
+
+	r, err = p.parseOptionLength("0")
+	assert.Equal(t, 0, r)
+	assert.NoError(t, err)
+
+	r, err = p.parseOptionLength("4")
+	assert.Equal(t, 4, r)
+	assert.NoError(t, err)
+
+	r, err = p.parseOptionLength("")
+	assert.Equal(t, 0, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptionLength("-1")
+	assert.Equal(t, 0, r)
+	assert.Error(t, err)
+
+	r, err = p.parseOptionLength("256")
+	assert.Equal(t, 0, r)
+	assert.Error(t, err)
+}
+
+func TestSignatureString(t *testing.T) {
+
+	var testData = []struct {
+		signature string
+		canonical string
+	}{
+		{"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0", "*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0"},
+		{"4:128:0:1460:mtu*2,0:mss,nop,ws::0", "4:128:0:1460:mtu*2,0:mss,nop,ws::0"},
+		{"*:64-:0:1460:3,10:ws,nop,mss,sok,nop,nop:uptr+,ecn:0", "*:64-:0:1460:3,10:ws,nop,mss,sok,nop,nop:ecn,uptr+:0"},
+		{"6:255:*:*:%8192,*:mss,tfo,mptcp,?253,eol:id+,df:+", "6:255:*:*:%8192,*:mss,?34,?30,?253,eol+0:df,id+:+"},
+		{"*:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:bad,opt+,exws,ts2+,ts1-:*", "*:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:ts1-,ts2+,opt+,exws,bad:*"},
+		{"*:64:0:*:*,0:::0", "*:64:0:*:*,0:::0"},
+	}
+
+	p := Parser{}
+	for _, item := range testData {
+		r, err := p.Parse(item.signature)
+		assert.NoError(t, err)
+		assert.Equal(t, item.canonical, r.String())
+
+		// canonical form is parsed to the same signature
+		c, err := p.Parse(r.String())
+		assert.NoError(t, err)
+		assert.Equal(t, item.canonical, c.String())
+	}
 }
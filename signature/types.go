@@ -8,6 +8,7 @@ type WindowType int
 
 const (
 	MaximumSegmentSizeWildcardIntValue = -1
+	OptionLengthWildcardIntValue       = -1
 	WindowScaleFactorWildcardIntValue  = -1
 
 	IpVersion4   IpVersion = "4"
@@ -31,6 +32,17 @@ const (
 	optionNameSACKPermitted string = "sok"
 	optionNameSACK          string = "sack"
 	optionNameTimestamps    string = "ts"
+	optionNameUnknown       string = "?"
+	// not a part of the original p0f signature format, p0f reports them as "?30" and "?34"
+	optionNameMPTCP    string = "mptcp"
+	optionNameFastOpen string = "tfo"
+
+	// maximum length of TCP options, data offset of the header is 4 bits of 32 bit words
+	MaxOptionsLength = 40
+
+	// TCP options not defined by gopacket
+	TCPOptionKindMPTCP    layers.TCPOptionKind = 30 // multipath TCP, RFC 8684
+	TCPOptionKindFastOpen layers.TCPOptionKind = 34 // TCP Fast Open cookie, RFC 7413
 
 	quirkDF       string = "df"   // "don't fragment" set (probably PMTUD); ignored for IPv6
 	quirkIdPlus   string = "id+"  // DF set but IPID non-zero; ignored for IPv6
@@ -88,9 +100,11 @@ type QuirkFlags struct {
 type Signature struct {
 	IpVersion  IpVersion
 	InitialTTL int
+	// TTL is random, InitialTTL is the maximum value ("64-")
+	RandomTTL bool
 	// Length of "Options" field of IP v4 structure
 	// https://en.wikipedia.org/wiki/Internet_Protocol_version_4#Options
-	//OptionLength       int
+	OptionLength       int
 	MaximumSegmentSize int
 	WindowSize         *WindowSize
 	PayloadSize        PayloadSize
@@ -0,0 +1,66 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+)
+
+// ECN codepoints of the 2 bit field in IP TOS byte
+// https://datatracker.ietf.org/doc/html/rfc3168#section-5
+const (
+	ecnNotECT uint8 = 0b00
+	ecnECT1   uint8 = 0b01
+	ecnECT0   uint8 = 0b10
+	ecnMask   uint8 = 0b11
+)
+
+// SpoofEcn makes IP and TCP layers of the same packet agree on ECN according to RFC 3168.
+// p0f reports "ecn" quirk if ECN bits are set in IP TOS or ECE/CWR/NS flags are set in TCP header.
+//
+// ECN-setup SYN has ECE and CWR flags set, ECN-setup SYN+ACK has only ECE flag set,
+// and both of them must not be marked as ECN-capable in IP header.
+// https://datatracker.ietf.org/doc/html/rfc3168#section-6.1.1
+//
+// SpoofIpLayer keeps ECN bits zero and SpoofTcpLayer sets ECE and CWR flags, so SpoofEcn is
+// only needed to mark packets other than SYN and SYN+ACK as ECN-capable.
+func SpoofEcn(ipv4 *layers.IPv4, tcp *layers.TCP, sig *signature.Signature) {
+	spoofTcpEcnFlags(tcp, sig)
+	ipv4.TOS = spoofEcnBits(ipv4.TOS, tcp, sig)
+}
+
+// spoofEcnBits returns IPv4 TOS or IPv6 traffic class with ECN bits of the packet
+func spoofEcnBits(tos uint8, tcp *layers.TCP, sig *signature.Signature) uint8 {
+
+	if sig.Quirks == nil || !sig.Quirks.ECN {
+		return tos & ^ecnMask
+	}
+
+	if tcp.SYN {
+		return tos & ^ecnMask
+	} else if tos&ecnMask == ecnNotECT {
+		return tos | ecnECT0
+	}
+
+	return tos
+}
+
+func spoofTcpEcnFlags(tcp *layers.TCP, sig *signature.Signature) {
+
+	if sig.Quirks == nil || !sig.Quirks.ECN {
+		tcp.ECE = false
+		tcp.CWR = false
+		tcp.NS = false
+		return
+	}
+
+	// ECN-setup SYN+ACK
+	if tcp.SYN && tcp.ACK {
+		tcp.ECE = true
+		tcp.CWR = false
+
+		// ECN-setup SYN
+	} else if tcp.SYN {
+		tcp.ECE = true
+		tcp.CWR = true
+	}
+}
@@ -0,0 +1,56 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSpoofEcn(t *testing.T) {
+
+	var testData = []struct {
+		ecn      bool
+		syn      bool
+		ack      bool
+		tos      uint8
+		ece      bool
+		cwr      bool
+		expected uint8
+	}{
+		{true, true, false, 0b10, true, true, 0},
+		{true, true, true, 0b01, true, false, 0},
+		{true, false, true, 0, false, false, ecnECT0},
+		{true, false, true, 0b100001, false, false, 0b100001},
+		{false, true, false, 0b100011, false, false, 0b100000},
+		{false, false, true, 0b11, false, false, 0},
+	}
+
+	for _, item := range testData {
+		sig := &signature.Signature{Quirks: &signature.QuirkFlags{ECN: item.ecn}}
+		ipv4 := &layers.IPv4{TOS: item.tos}
+		tcp := &layers.TCP{SYN: item.syn, ACK: item.ack, ECE: !item.ece, CWR: !item.cwr, NS: true}
+
+		SpoofEcn(ipv4, tcp, sig)
+		assert.Equal(t, item.expected, ipv4.TOS)
+		// flags of established connection are not touched
+		if item.syn || !item.ecn {
+			assert.Equal(t, item.ece, tcp.ECE)
+			assert.Equal(t, item.cwr, tcp.CWR)
+		}
+		if !item.ecn {
+			assert.False(t, tcp.NS)
+		}
+	}
+
+	// SYN is not ECN-capable in IP header, "ecn" quirk is expressed by TCP flags
+	sig := &signature.Signature{Quirks: &signature.QuirkFlags{ECN: true}}
+	ipv4 := &layers.IPv4{TOS: 0xFF}
+	SpoofIpLayer(ipv4, sig)
+	assert.Equal(t, ecnNotECT, ipv4.TOS&ecnMask)
+	assert.Equal(t, uint8(0xFC), ipv4.TOS)
+
+	ipv6 := &layers.IPv6{TrafficClass: ecnECT0}
+	SpoofIpv6Layer(ipv6, sig)
+	assert.Equal(t, ecnNotECT, ipv6.TrafficClass&ecnMask)
+}
@@ -3,20 +3,34 @@ package p0f
 import (
 	"github.com/alytsin/go-p0f/signature"
 	"github.com/google/gopacket/layers"
-	"math/rand"
 )
 
 func SpoofIpLayer(ipv4 *layers.IPv4, sig *signature.Signature) {
+	SpoofIpLayerWithIdGenerator(ipv4, sig, nil)
+}
+
+// SpoofIpLayerWithIdGenerator is SpoofIpLayer which takes IP identification from the generator
+// whenever signature allows non-zero ID, e.g. to mimic sequences of the target OS.
+// ID of the packet is kept if generator is nil.
+func SpoofIpLayerWithIdGenerator(ipv4 *layers.IPv4, sig *signature.Signature, generator IpIdGenerator) {
+	spoofIpLayer(ipv4, sig, generator, globalRandom{})
+}
+
+func spoofIpLayer(ipv4 *layers.IPv4, sig *signature.Signature, generator IpIdGenerator, rnd random) {
 
 	// https://blog.cloudflare.com/introducing-the-p0f-bpf-compiler
 
-	// set to zero ECN bits in TOS byte
-	tos := ipv4.TOS & ^uint8(0b11)
+	// ECN bits in TOS byte are zero, SYN and SYN+ACK must not be ECN-capable, "ecn" quirk
+	// is expressed by ECE and CWR flags of SpoofTcpLayer, use SpoofEcn for other TCP packets
+	tos := ipv4.TOS & ^ecnMask
 	flags := ipv4.Flags
-	quirks := sig.Quirks
+	quirks := quirksOf(sig)
 
 	// https://en.wikipedia.org/wiki/Internet_Protocol_version_4#Identification
 	identification := ipv4.Id
+	if generator != nil {
+		identification = nextIpId(generator, ipv4, rnd)
+	}
 
 	// DF: don't fragment bit is set in the IP header
 	if quirks.DF {
@@ -25,7 +39,7 @@ func SpoofIpLayer(ipv4 *layers.IPv4, sig *signature.Signature) {
 		// id+: df bit is set and IP identification field is non-zero
 		if quirks.IdPlus {
 			if identification == 0 {
-				identification = uint16(rand.Intn(0xFFFF-1) + 1)
+				identification = uint16(rnd.Intn(0xFFFF-1) + 1)
 			}
 		} else {
 			identification = 0
@@ -39,7 +53,7 @@ func SpoofIpLayer(ipv4 *layers.IPv4, sig *signature.Signature) {
 		if quirks.IdMinus {
 			identification = 0
 		} else if identification == 0 {
-			identification = uint16(rand.Intn(0xFFFF-1) + 1)
+			identification = uint16(rnd.Intn(0xFFFF-1) + 1)
 		}
 	}
 
@@ -52,14 +66,15 @@ func SpoofIpLayer(ipv4 *layers.IPv4, sig *signature.Signature) {
 		flags = flags & ^layers.IPv4EvilBit
 	}
 
-	// ecn: explicit congestion flag is set, 2 bit field
-	// https://en.wikipedia.org/wiki/Internet_Protocol_version_4#ECN
-	// https://en.wikipedia.org/wiki/Explicit_Congestion_Notification#Operation_of_ECN_with_IP
-	if quirks.ECN {
-		tos = tos & uint8(rand.Intn(0b11-1)+1)
-	}
-
 	ipv4.TOS = tos
 	ipv4.Flags = flags
 	ipv4.Id = identification
 }
+
+// quirksOf returns quirks of the signature, signature without quirks has nil flags
+func quirksOf(sig *signature.Signature) *signature.QuirkFlags {
+	if sig.Quirks == nil {
+		return &signature.QuirkFlags{}
+	}
+	return sig.Quirks
+}
@@ -0,0 +1,29 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+)
+
+// SpoofIpv6Layer is SpoofIpLayer for IPv6, quirks of IPv4 header (df, id+, id-, 0+) are not applicable.
+// Hop limit is not changed, use Spoofer to set it from initial TTL of the signature.
+func SpoofIpv6Layer(ipv6 *layers.IPv6, sig *signature.Signature) {
+	spoofIpv6Layer(ipv6, sig, globalRandom{})
+}
+
+func spoofIpv6Layer(ipv6 *layers.IPv6, sig *signature.Signature, rnd random) {
+
+	quirks := quirksOf(sig)
+
+	// flow: non-zero IPv6 flow label, 20 bits
+	if quirks.Flow {
+		if ipv6.FlowLabel == 0 || ipv6.FlowLabel > 0xFFFFF {
+			ipv6.FlowLabel = uint32(rnd.Intn(0xFFFFF-1) + 1)
+		}
+	} else {
+		ipv6.FlowLabel = 0
+	}
+
+	// ECN bits are the same 2 bits of traffic class as of IPv4 TOS byte and are zero, see SpoofIpLayer
+	ipv6.TrafficClass = ipv6.TrafficClass & ^ecnMask
+}
@@ -3,15 +3,47 @@ package p0f
 import (
 	"github.com/alytsin/go-p0f/signature"
 	"github.com/google/gopacket/layers"
-	"math/rand"
 )
 
+// SpoofTcpLayer rewrites TCP layer to match signature, the layer is not changed
+// if options of the signature do not fit TCP header, see SpoofTcpOptions
 func SpoofTcpLayer(tcp *layers.TCP, sig *signature.Signature) {
+	SpoofTcpLayerWithHints(tcp, sig, HintPolicies{})
+}
+
+// SpoofTcpLayerWithHints is SpoofTcpLayer which uses values of the packet options
+// according to hint policies, e.g. to keep the real path MSS
+func SpoofTcpLayerWithHints(tcp *layers.TCP, sig *signature.Signature, hints HintPolicies) {
+	_ = spoofTcpLayer(tcp, sig, hints, signature.IpVersion4, globalRandom{}, nil)
+}
+
+// spoofTcpLayer spoofs TCP layer of IP version, it is used for "mtu*N" window size.
+// Options are built in buffer if it is not nil. ErrOptionsOverflow is returned and
+// the layer is not changed if options do not fit TCP header.
+func spoofTcpLayer(tcp *layers.TCP, sig *signature.Signature, hints HintPolicies, version signature.IpVersion, rnd random, buffer *tcpOptionsBuffer) error {
 
 	ackNumber := tcp.Ack
 	sequenceNumber := tcp.Seq
 	urgentPointer := tcp.Urgent
-	quirks := sig.Quirks
+	quirks := quirksOf(sig)
+
+	// options depend on ACK flag and payload, they are built first with flag and payload
+	// of the signature, so the layer is restored if options do not fit the header
+	ackFlag, payload := tcp.ACK, tcp.Payload
+	if quirks.AckPlus {
+		tcp.ACK = false
+	} else if quirks.AckMinus {
+		tcp.ACK = true
+	}
+	// payload size class "0", payload for other classes is attached by SpoofTcpPayload
+	if sig.PayloadSize == signature.PayloadSizeZero {
+		tcp.Payload = nil
+	}
+
+	if err := spoofTcpOptions(tcp, sig, hints, version, rnd, buffer); err != nil {
+		tcp.ACK, tcp.Payload = ackFlag, payload
+		return err
+	}
 
 	// https://en.wikipedia.org/wiki/Transmission_Control_Protocol#TCP_segment_structure
 	// https://datatracker.ietf.org/doc/html/rfc791#section-3.1
@@ -20,31 +52,38 @@ func SpoofTcpLayer(tcp *layers.TCP, sig *signature.Signature) {
 	if quirks.SeqMinus {
 		sequenceNumber = 0
 	} else if sequenceNumber == 0 {
-		sequenceNumber = uint32(rand.Intn(0xFFFFFFFF-1) + 1)
+		sequenceNumber = uint32(rnd.Int63n(0xFFFFFFFF) + 1)
 	}
 
 	// ACK number is non-zero, but ACK flag not set
 	if quirks.AckPlus {
-		tcp.ACK = false
 		if ackNumber == 0 {
-			ackNumber = uint32(rand.Intn(0xFFFFFFFF-1) + 1)
+			ackNumber = uint32(rnd.Int63n(0xFFFFFFFF) + 1)
 		}
 
 		// ACK number is zero, but ACK flag set
 	} else if quirks.AckMinus {
-		tcp.ACK = true
 		ackNumber = 0
+
+		// ACK number is consistent with ACK flag
+	} else if !tcp.ACK {
+		ackNumber = 0
+	} else if ackNumber == 0 {
+		ackNumber = uint32(rnd.Int63n(0xFFFFFFFF) + 1)
 	}
 
 	// URG pointer is non-zero, but URG flag not set
 	if quirks.UptrPlus {
 		tcp.URG = false
 		if urgentPointer == 0 {
-			urgentPointer = uint16(rand.Intn(0xFFFF-1) + 1)
+			urgentPointer = uint16(rnd.Intn(0xFFFF-1) + 1)
 		}
 		// URG flag used
 	} else if quirks.UrgfPlus {
 		tcp.URG = true
+	} else {
+		tcp.URG = false
+		urgentPointer = 0
 	}
 
 	tcp.Seq = sequenceNumber
@@ -53,6 +92,7 @@ func SpoofTcpLayer(tcp *layers.TCP, sig *signature.Signature) {
 	// PUSH flag used
 	tcp.PSH = quirks.PushfPlus
 
-	SpoofTcpOptions(tcp, sig)
-	SpoofTcpWindow(tcp, sig)
+	spoofTcpEcnFlags(tcp, sig)
+	spoofTcpWindow(tcp, sig, version, rnd)
+	return nil
 }
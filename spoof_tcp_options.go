@@ -2,13 +2,82 @@ package p0f
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/alytsin/go-p0f/signature"
 	"github.com/google/gopacket/layers"
-	"math"
-	"math/rand"
 )
 
-func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
+var (
+	// ErrWindowOverflow is returned by Spoofer if window size of the signature does not fit 16 bits for any MSS
+	ErrWindowOverflow = errors.New("window size of the signature overflows 16 bits")
+	// ErrOptionsOverflow is returned if options of the signature do not fit 40 bytes of TCP header
+	ErrOptionsOverflow = errors.New("TCP options overflow 40 bytes")
+)
+
+func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) error {
+	return SpoofTcpOptionsWithHints(tcp, sig, HintPolicies{})
+}
+
+// SpoofTcpOptionsWithHints builds options layout of the signature,
+// values of the packet options are used according to hint policies.
+// ErrOptionsOverflow is returned and options are not changed if they do not fit TCP header,
+// e.g. for large "eol+n" padding or long data of the packet options kept by "?n".
+func SpoofTcpOptionsWithHints(tcp *layers.TCP, sig *signature.Signature, hints HintPolicies) error {
+	return spoofTcpOptions(tcp, sig, hints, signature.IpVersion4, globalRandom{}, nil)
+}
+
+// tcpOptionsBuffer keeps options and their data between packets to build options without allocations,
+// options of the packet refer to the buffer until it is reset
+type tcpOptionsBuffer struct {
+	options []layers.TCPOption
+	data    [2 * maxTcpOptionsLength]byte
+	used    int
+}
+
+// maximum length of TCP options, data offset is 4 bits of 32 bit words
+const maxTcpOptionsLength = signature.MaxOptionsLength
+
+func (b *tcpOptionsBuffer) reset() {
+	if b != nil {
+		b.options = b.options[:0]
+		b.used = 0
+	}
+}
+
+// alloc returns zeroed slice of the buffer, or a new slice if buffer is nil or full
+func (b *tcpOptionsBuffer) alloc(n int) []byte {
+
+	if b == nil || b.used+n > len(b.data) {
+		return make([]byte, n)
+	}
+
+	data := b.data[b.used : b.used+n : b.used+n]
+	clear(data)
+	b.used += n
+	return data
+}
+
+func (b *tcpOptionsBuffer) optionSlice() []layers.TCPOption {
+	if b == nil {
+		return nil
+	}
+	return b.options[:0]
+}
+
+// tcpOptionData returns data of the first option of the kind
+func tcpOptionData(options []layers.TCPOption, kind layers.TCPOptionKind) []byte {
+	for _, option := range options {
+		if option.OptionType == kind {
+			return option.OptionData
+		}
+	}
+	return nil
+}
+
+// spoofTcpOptions builds options in buffer, buffer may be nil. IP version is used for MSS range of "mtu*N" window size.
+// The layer is changed only if options fit TCP header.
+func spoofTcpOptions(tcp *layers.TCP, sig *signature.Signature, hints HintPolicies, version signature.IpVersion, rnd random, buffer *tcpOptionsBuffer) error {
 
 	var mssHint uint16 = 0
 	var mssFound = false
@@ -20,6 +89,11 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 	var wsHint uint8 = 0
 	var wsFound = false
 
+	var sackHint []byte
+
+	// data of options without own hint rules, e.g. TFO cookie, is found with tcpOptionData
+	packetOptions := tcp.Options
+
 	for _, option := range tcp.Options {
 		switch option.OptionType {
 
@@ -33,18 +107,55 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 				mssHint = binary.BigEndian.Uint16(option.OptionData)
 			}
 		case layers.TCPOptionKindTimestamps:
-			tsFound = true
-			ts1Hint = binary.BigEndian.Uint32(option.OptionData[:4])
-			ts2Hint = binary.BigEndian.Uint32(option.OptionData[4:8])
+			if len(option.OptionData) >= 8 {
+				tsFound = true
+				ts1Hint = binary.BigEndian.Uint32(option.OptionData[:4])
+				ts2Hint = binary.BigEndian.Uint32(option.OptionData[4:8])
+			}
 		case layers.TCPOptionKindWindowScale:
-			wsFound = true
-			wsHint = option.OptionData[0]
+			if len(option.OptionData) >= 1 {
+				wsFound = true
+				wsHint = option.OptionData[0]
+			}
+		case layers.TCPOptionKindSACK:
+			// each SACK block is a pair of 32 bit edges
+			if len(option.OptionData) > 0 && len(option.OptionData)%8 == 0 {
+				sackHint = option.OptionData
+			}
 		}
 	}
 
-	var newOptions []layers.TCPOption
+	switch hints.MSS {
+	case HintRandomize:
+		mssFound = false
+	case HintFromConfig:
+		mssFound = true
+		mssHint = hints.MSSValue
+	}
 
-	for _, sigOption := range sig.OptionsLayout {
+	switch hints.WindowScale {
+	case HintRandomize:
+		wsFound = false
+	case HintFromConfig:
+		wsFound = true
+		wsHint = hints.WindowScaleValue
+	}
+
+	switch hints.Timestamps {
+	case HintRandomize:
+		ts1Hint = 0
+	case HintFromConfig:
+		tsFound = true
+		ts1Hint = hints.TimestampValue
+	}
+
+	quirks := quirksOf(sig)
+
+	newOptions := buffer.optionSlice()
+	eolPadding := 0
+
+LAYOUT:
+	for i, sigOption := range sig.OptionsLayout {
 		switch sigOption {
 		case layers.TCPOptionKindWindowScale:
 			var ws uint8
@@ -54,17 +165,17 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 				var maxWs uint8 = 0xFF
 
 				// excessive window scaling factor (> 14)
-				if sig.Quirks.EXWS {
-					if wsFound && wsHint > 14 && wsHint < maxWs {
+				if quirks.EXWS {
+					if wsFound && (hints.WindowScale == HintFromConfig || wsHint > 14 && wsHint < maxWs) {
 						ws = wsHint
 					} else {
-						ws = uint8(rand.Int31n(0xFF-15) + 15)
+						ws = uint8(rnd.Int31n(0xFF-15) + 15)
 					}
 				} else {
-					if wsFound {
+					if wsFound && (hints.WindowScale == HintFromConfig || wsHint <= 14) {
 						ws = wsHint
 					} else {
-						ws = uint8(rand.Int31n(14-1) + 1)
+						ws = uint8(rnd.Int31n(14-1) + 1)
 					}
 				}
 
@@ -72,35 +183,29 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 				ws = uint8(sig.WindowSize.WindowScalingFactor)
 			}
 
+			wsData := buffer.alloc(1)
+			wsData[0] = ws
+
 			newOptions = append(newOptions, layers.TCPOption{
 				OptionType:   layers.TCPOptionKindWindowScale,
 				OptionLength: 3,
-				OptionData:   []byte{ws},
+				OptionData:   wsData,
 			})
 
 		case layers.TCPOptionKindMSS:
 
-			mss := make([]byte, 2)
+			mss := buffer.alloc(2)
 
 			if sig.MaximumSegmentSize == signature.MaximumSegmentSizeWildcardIntValue {
-				var maxMss uint16 = 0xFFFF
-
-				// in case of windows size in signature has format "mss*X"
-				if sig.WindowSize.WindowSizeType == signature.WindowTypeMSS {
-					maxMss = uint16(math.Floor(0xFFFF / float64(sig.WindowSize.WindowSize)))
-				}
+				// window size overflows for any MSS if the range is empty, the smallest MSS is used then
+				minMss, maxMss, _ := mssRange(sig, version)
 
-				// https://datatracker.ietf.org/doc/html/rfc791#section-3.1
-				// The number 576 is selected to allow a reasonable sized data block to
-				// be transmitted in addition to the required header information.
-				// Since TCP uses 40 bytes of overhead, then the minimum MSS is 536 bytes.
-				var minMss uint16 = 536
-
-				if mssFound && mssHint >= minMss && mssHint <= maxMss {
+				if mssFound && (hints.MSS == HintFromConfig || int(mssHint) >= minMss && int(mssHint) <= maxMss) {
 					binary.BigEndian.PutUint16(mss, mssHint)
+				} else if minMss > maxMss {
+					binary.BigEndian.PutUint16(mss, uint16(minMss))
 				} else {
-					// TODO: test this subtraction
-					binary.BigEndian.PutUint16(mss, uint16(rand.Int31n(int32(maxMss-minMss))+int32(minMss)))
+					binary.BigEndian.PutUint16(mss, uint16(rnd.Intn(maxMss-minMss+1)+minMss))
 				}
 
 			} else {
@@ -117,24 +222,24 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 		case layers.TCPOptionKindTimestamps:
 
 			// own timestamp specified as zero
-			if sig.Quirks.TsMinus {
+			if quirks.TsMinus {
 				ts1Hint = 0
-			} else if !tsFound || ts1Hint == 0 {
-				// just random values
-				ts1Hint = uint32(rand.Intn((0xFFFFFFFF - 0xFF) + 0xFF))
+			} else if hints.Timestamps != HintFromConfig && (!tsFound || ts1Hint == 0) {
+				// just random non-zero values, zero is "ts1-" quirk
+				ts1Hint = uint32(rnd.Int63n(0xFFFFFFFF-0xFF) + 0xFF)
 			}
 
 			// non-zero peer timestamp on initial SYN
-			if sig.Quirks.TsPlus && tcp.SYN {
+			if quirks.TsPlus && tcp.SYN {
 				if !tsFound || ts2Hint == 0 {
 					// just random values
-					ts2Hint = uint32(rand.Intn((0xFFFFFFFF - 0xFF) + 0xFF))
+					ts2Hint = uint32(rnd.Int63n(0xFFFFFFFF-0xFF) + 0xFF)
 				}
 			} else {
 				ts2Hint = 0
 			}
 
-			tsData := make([]byte, 8)
+			tsData := buffer.alloc(8)
 			binary.BigEndian.PutUint32(tsData, ts1Hint)
 			binary.BigEndian.PutUint32(tsData[4:], ts2Hint)
 
@@ -154,13 +259,208 @@ func SpoofTcpOptions(tcp *layers.TCP, sig *signature.Signature) {
 				OptionType:   layers.TCPOptionKindNop,
 				OptionLength: 0,
 			})
+		case layers.TCPOptionKindSACK:
+
+			// selective ACK should not be seen on SYN, but if signature has it,
+			// at least one valid block (left edge, right edge) is required
+			sackData := sackHint
+			if sackData == nil {
+				left := rnd.Uint32()
+				right := left + uint32(rnd.Int31n(0xFFFF-1)+1)
+				sackData = buffer.alloc(8)
+				binary.BigEndian.PutUint32(sackData, left)
+				binary.BigEndian.PutUint32(sackData[4:], right)
+			}
+
+			newOptions = append(newOptions, layers.TCPOption{
+				OptionType:   layers.TCPOptionKindSACK,
+				OptionLength: uint8(len(sackData) + 2),
+				OptionData:   sackData,
+			})
+
+		case signature.TCPOptionKindFastOpen:
+			newOptions = append(newOptions, spoofFastOpenOption(tcp, tcpOptionData(packetOptions, sigOption), rnd, buffer))
+
+		case signature.TCPOptionKindMPTCP:
+			newOptions = append(newOptions, spoofMPTCPOption(tcp, tcpOptionData(packetOptions, sigOption), rnd, buffer))
+
 		case layers.TCPOptionKindEndList:
 			newOptions = append(newOptions, layers.TCPOption{
 				OptionType:   layers.TCPOptionKindEndList,
 				OptionLength: 0,
 			})
+
+			// "eol+n" is parsed as EOL followed by n more EOL entries,
+			// these are n bytes of zero padding after the end of options list
+			eolPadding = len(sig.OptionsLayout) - i - 1
+			break LAYOUT
+
+		default:
+			// unknown option "?n", data of the packet option is kept if any
+			data := tcpOptionData(packetOptions, sigOption)
+			newOptions = append(newOptions, layers.TCPOption{
+				OptionType:   sigOption,
+				OptionLength: uint8(len(data) + 2),
+				OptionData:   data,
+			})
+		}
+	}
+
+	// header length must match the options layout exactly, so the length of options
+	// is aligned to 32 bit words with zero padding only if layout itself is not aligned
+	optionsLength := eolPadding
+	for _, option := range newOptions {
+		switch option.OptionType {
+		case layers.TCPOptionKindEndList, layers.TCPOptionKindNop:
+			optionsLength++
+		default:
+			optionsLength += len(option.OptionData) + 2
+		}
+	}
+	alignment := 0
+	if rem := optionsLength % 4; rem != 0 {
+		alignment = 4 - rem
+		optionsLength += alignment
+	}
+
+	// data offset would be truncated to 4 bits and corrupt the header
+	if optionsLength > maxTcpOptionsLength {
+		return fmt.Errorf("%w: %d bytes of '%s'", ErrOptionsOverflow, optionsLength, sig)
+	}
+
+	var padding []byte
+	if eolPadding+alignment > 0 {
+		padding = buffer.alloc(eolPadding + alignment)
+	}
+
+	// opt+: trailing non-zero data in options segment,
+	// otherwise padding stays zero. Note that gopacket replaces padding with zeros
+	// if the layer is serialized with FixLengths and the options are not aligned
+	// to 32 bit words without it
+	if quirks.OptPlus {
+		for n := 0; n < eolPadding; n++ {
+			padding[n] = uint8(rnd.Intn(0xFF) + 1)
 		}
 	}
 
+	// bad: malformed TCP options
+	if quirks.Bad {
+		malformTcpOptions(newOptions, optionsLength)
+	}
+
+	if buffer != nil {
+		buffer.options = newOptions
+	}
+
 	tcp.Options = newOptions
+	tcp.Padding = padding
+	tcp.DataOffset = uint8((20 + optionsLength) / 4)
+	return nil
+}
+
+// spoofFastOpenOption returns TCP Fast Open option, cookie of the packet option is kept if valid.
+// SYN without data requests a cookie with empty option, SYN with data and SYN+ACK carry a cookie.
+// https://datatracker.ietf.org/doc/html/rfc7413#section-4.1.1
+func spoofFastOpenOption(tcp *layers.TCP, cookie []byte, rnd random, buffer *tcpOptionsBuffer) layers.TCPOption {
+
+	// cookie is 4 to 16 bytes long and has even length
+	valid := len(cookie) >= 4 && len(cookie) <= 16 && len(cookie)%2 == 0
+
+	if !valid && (tcp.ACK || len(tcp.Payload) > 0) {
+		// Linux and Apple clients use 8 bytes cookies
+		cookie = buffer.alloc(8)
+		binary.BigEndian.PutUint64(cookie, rnd.Uint64())
+	} else if !valid {
+		cookie = nil
+	}
+
+	return layers.TCPOption{
+		OptionType:   signature.TCPOptionKindFastOpen,
+		OptionLength: uint8(len(cookie) + 2),
+		OptionData:   cookie,
+	}
+}
+
+// spoofMPTCPOption returns MP_CAPABLE option of multipath TCP version 1, data of the packet option
+// is kept if it is MP_CAPABLE option. SYN carries no key, SYN+ACK carries key of the sender.
+// https://datatracker.ietf.org/doc/html/rfc8684#section-3.1
+func spoofMPTCPOption(tcp *layers.TCP, data []byte, rnd random, buffer *tcpOptionsBuffer) layers.TCPOption {
+
+	// subtype is the high nibble of the first byte, MP_CAPABLE is zero
+	if len(data) < 2 || data[0]>>4 != 0 {
+		// version 1, flags: HMAC-SHA256 ("H" bit)
+		length := 2
+		if tcp.ACK {
+			length += 8
+		}
+
+		data = buffer.alloc(length)
+		data[0] = 0x01
+		data[1] = 0x01
+		if tcp.ACK {
+			binary.BigEndian.PutUint64(data[2:], rnd.Uint64())
+		}
+	}
+
+	return layers.TCPOption{
+		OptionType:   signature.TCPOptionKindMPTCP,
+		OptionLength: uint8(len(data) + 2),
+		OptionData:   data,
+	}
+}
+
+// malformTcpOptions sets length of the last fixed size option so that it overruns the header,
+// p0f expects exact length of these options and reports "bad" quirk otherwise.
+// Use ExactTcpLayer to serialize such options, gopacket normalizes lengths with FixLengths.
+func malformTcpOptions(options []layers.TCPOption, optionsLength int) {
+
+	last := -1
+	lastOffset := 0
+	offset := 0
+
+	for i, option := range options {
+		switch option.OptionType {
+		case layers.TCPOptionKindEndList, layers.TCPOptionKindNop:
+			offset++
+			continue
+		case layers.TCPOptionKindMSS, layers.TCPOptionKindWindowScale,
+			layers.TCPOptionKindSACKPermitted, layers.TCPOptionKindTimestamps:
+			last = i
+			lastOffset = offset
+		}
+		offset += len(option.OptionData) + 2
+	}
+
+	// options layout has no option with fixed size, it can not be malformed
+	// without changing the layout
+	if last < 0 {
+		return
+	}
+
+	options[last].OptionLength = uint8(optionsLength - lastOffset + 1)
+}
+
+// mssRange returns range of MSS values of the signature with "*" MSS, "mss*N" and "mtu*N" window sizes
+// limit MSS to keep window size within 16 bits. The minimum MSS is lowered down to 1 for large N,
+// ErrWindowOverflow is returned if even MSS 1 overflows window size, the range is empty then.
+func mssRange(sig *signature.Signature, version signature.IpVersion) (int, int, error) {
+
+	// https://datatracker.ietf.org/doc/html/rfc791#section-3.1
+	// The number 576 is selected to allow a reasonable sized data block to
+	// be transmitted in addition to the required header information.
+	// Since TCP uses 40 bytes of overhead, then the minimum MSS is 536 bytes.
+	minMss, maxMss := 536, 0xFFFF
+
+	switch sig.WindowSize.WindowSizeType {
+	case signature.WindowTypeMSS:
+		maxMss = 0xFFFF / int(sig.WindowSize.WindowSize)
+	case signature.WindowTypeMTU:
+		maxMss = 0xFFFF/int(sig.WindowSize.WindowSize) - signature.MTU(0, version)
+	}
+
+	if maxMss < 1 {
+		return 1, maxMss, fmt.Errorf("%w: '%s'", ErrWindowOverflow, sig)
+	}
+
+	return min(minMss, maxMss), maxMss, nil
 }
@@ -0,0 +1,266 @@
+package p0f
+
+import (
+	"encoding/binary"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSpoofTcpOptionsLength(t *testing.T) {
+
+	var testData = []struct {
+		signature    string
+		headerLength int
+		layout       []layers.TCPOptionKind
+		padding      []byte
+	}{
+		{
+			"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0", 40,
+			[]layers.TCPOptionKind{
+				layers.TCPOptionKindMSS, layers.TCPOptionKindSACKPermitted, layers.TCPOptionKindTimestamps,
+				layers.TCPOptionKindNop, layers.TCPOptionKindWindowScale,
+			},
+			nil,
+		},
+		{
+			"*:64:0:*:65535,6:mss,nop,ws,nop,nop,ts,sok,eol+1:df,id+:0", 44,
+			[]layers.TCPOptionKind{
+				layers.TCPOptionKindMSS, layers.TCPOptionKindNop, layers.TCPOptionKindWindowScale,
+				layers.TCPOptionKindNop, layers.TCPOptionKindNop, layers.TCPOptionKindTimestamps,
+				layers.TCPOptionKindSACKPermitted, layers.TCPOptionKindEndList,
+			},
+			[]byte{0},
+		},
+		{
+			"*:64:0:*:mss*20,10:mss,sok,ts,nop,ws,mptcp,tfo,nop,nop,?253:df,id+:0", 52,
+			[]layers.TCPOptionKind{
+				layers.TCPOptionKindMSS, layers.TCPOptionKindSACKPermitted, layers.TCPOptionKindTimestamps,
+				layers.TCPOptionKindNop, layers.TCPOptionKindWindowScale, signature.TCPOptionKindMPTCP,
+				signature.TCPOptionKindFastOpen, layers.TCPOptionKindNop, layers.TCPOptionKindNop, 253,
+				layers.TCPOptionKindEndList,
+			},
+			[]byte{0},
+		},
+		{
+			"*:64:0:*:65535,*:mss,sack,eol+1:df:0", 36,
+			[]layers.TCPOptionKind{layers.TCPOptionKindMSS, layers.TCPOptionKindSACK, layers.TCPOptionKindEndList},
+			[]byte{0},
+		},
+		{
+			"*:64:0:*:65535,*:mss,nop:df:0", 28,
+			// unaligned layout is padded with zeros, these are seen as "eol+2"
+			[]layers.TCPOptionKind{layers.TCPOptionKindMSS, layers.TCPOptionKindNop, layers.TCPOptionKindEndList},
+			[]byte{0, 0},
+		},
+	}
+
+	parser := signature.Parser{}
+
+	for _, item := range testData {
+		sig, err := parser.Parse(item.signature)
+		assert.NoError(t, err)
+
+		for _, fixLengths := range []bool{false, true} {
+			tcp := &layers.TCP{SYN: true}
+			SpoofTcpOptions(tcp, sig)
+
+			buf := gopacket.NewSerializeBuffer()
+			err = tcp.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: fixLengths})
+			assert.NoError(t, err)
+			assert.Len(t, buf.Bytes(), item.headerLength, item.signature)
+
+			decoded := &layers.TCP{}
+			err = decoded.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback)
+			assert.NoError(t, err)
+			assert.Equal(t, item.headerLength, int(decoded.DataOffset)*4, item.signature)
+
+			var layout []layers.TCPOptionKind
+			for _, option := range decoded.Options {
+				layout = append(layout, option.OptionType)
+				if option.OptionType == layers.TCPOptionKindSACK {
+					assert.Len(t, option.OptionData, 8)
+				}
+			}
+			assert.Equal(t, item.layout, layout, item.signature)
+			assert.Equal(t, item.padding, []byte(decoded.Padding), item.signature)
+		}
+	}
+}
+
+func TestSpoofTcpOptionsOptPlus(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	sig, err := parser.Parse("*:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:df,opt+:0")
+	assert.NoError(t, err)
+
+	tcp := &layers.TCP{SYN: true}
+	SpoofTcpOptions(tcp, sig)
+	assert.Len(t, tcp.Padding, 1)
+	assert.NotEqual(t, uint8(0), tcp.Padding[0])
+
+	sig, err = parser.Parse("*:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:df:0")
+	assert.NoError(t, err)
+
+	tcp = &layers.TCP{SYN: true, Padding: []byte{0xFF}}
+	SpoofTcpOptions(tcp, sig)
+	assert.Equal(t, []byte{0}, tcp.Padding)
+}
+
+func TestSpoofTcpOptionsBad(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	sig, err := parser.Parse("*:64:0:*:65535,*:mss,nop,ws,nop,nop,ts,sok,eol+1:df,opt+,bad:0")
+	assert.NoError(t, err)
+
+	tcp := &layers.TCP{SYN: true}
+	SpoofTcpOptions(tcp, sig)
+
+	buf := gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ExactTcpLayer{TCP: tcp})
+	assert.NoError(t, err)
+
+	data := buf.Bytes()
+	assert.Len(t, data, 44)
+	assert.Equal(t, uint8(44/4), data[12]>>4)
+
+	// "sok" starts at offset 20 of options and overruns the header
+	assert.Equal(t, uint8(layers.TCPOptionKindSACKPermitted), data[40])
+	assert.Equal(t, uint8(5), data[41])
+
+	// opt+ padding survives serialization
+	assert.Equal(t, uint8(0), data[42])
+	assert.NotEqual(t, uint8(0), data[43])
+
+	decoded := &layers.TCP{}
+	assert.Error(t, decoded.DecodeFromBytes(data, gopacket.NilDecodeFeedback))
+}
+
+func TestSpoofTcpOptionsFastOpenAndMPTCP(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	sig, err := parser.Parse("*:64:0:*:65535,*:mss,?30,?34:df:0")
+	assert.NoError(t, err)
+
+	// cookie request and MP_CAPABLE without key on SYN
+	tcp := &layers.TCP{SYN: true}
+	SpoofTcpOptions(tcp, sig)
+	assert.Equal(t, []byte{0x01, 0x01}, tcp.Options[1].OptionData)
+	assert.Equal(t, uint8(4), tcp.Options[1].OptionLength)
+	assert.Nil(t, tcp.Options[2].OptionData)
+	assert.Equal(t, uint8(2), tcp.Options[2].OptionLength)
+
+	// cookie and MP_CAPABLE with key on SYN+ACK
+	tcp = &layers.TCP{SYN: true, ACK: true}
+	SpoofTcpOptions(tcp, sig)
+	assert.Len(t, tcp.Options[1].OptionData, 10)
+	assert.Len(t, tcp.Options[2].OptionData, 8)
+
+	// valid cookie of the packet is kept
+	cookie := []byte{1, 2, 3, 4, 5, 6}
+	tcp = &layers.TCP{SYN: true, Options: []layers.TCPOption{
+		{OptionType: signature.TCPOptionKindFastOpen, OptionLength: 8, OptionData: cookie},
+	}}
+	SpoofTcpOptions(tcp, sig)
+	assert.Equal(t, cookie, tcp.Options[2].OptionData)
+}
+
+func TestSpoofTcpOptionsOverflow(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	// layout fits 40 bytes, but data of the packet option "?253" does not
+	sig, err := parser.Parse("*:64:0:*:65535,*:mss,?253,ts,ts,ts:df:0")
+	assert.NoError(t, err)
+
+	unknown := []layers.TCPOption{{OptionType: 253, OptionLength: 12, OptionData: make([]byte, 10)}}
+	tcp := &layers.TCP{SYN: true, Seq: 1, DataOffset: 5, Options: unknown}
+	assert.ErrorIs(t, SpoofTcpOptions(tcp, sig), ErrOptionsOverflow)
+	assert.Equal(t, unknown, tcp.Options)
+	assert.Equal(t, uint8(5), tcp.DataOffset)
+
+	// free function does not change the layer
+	SpoofTcpLayer(tcp, sig)
+	assert.Equal(t, &layers.TCP{SYN: true, Seq: 1, DataOffset: 5, Options: unknown}, tcp)
+
+	// layout which is not parsed by Parser
+	sig.OptionsLayout = make([]layers.TCPOptionKind, 60)
+	assert.ErrorIs(t, SpoofTcpOptions(&layers.TCP{SYN: true}, sig), ErrOptionsOverflow)
+
+	tcp.Options = nil
+	assert.NoError(t, SpoofTcpOptions(tcp, &signature.Signature{
+		OptionsLayout: []layers.TCPOptionKind{layers.TCPOptionKindMSS, 253, layers.TCPOptionKindTimestamps},
+		WindowSize:    &signature.WindowSize{},
+		Quirks:        &signature.QuirkFlags{},
+	}))
+	assert.Equal(t, uint8(9), tcp.DataOffset)
+}
+
+func TestSpoofTcpOptionsHints(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	sig, err := parser.Parse("*:64:0:*:mss*20,*:mss,sok,ts,nop,ws:df,id+:0")
+	assert.NoError(t, err)
+
+	packet := func(mss uint16, ws uint8, ts uint32) *layers.TCP {
+		mssData := make([]byte, 2)
+		binary.BigEndian.PutUint16(mssData, mss)
+		tsData := make([]byte, 8)
+		binary.BigEndian.PutUint32(tsData, ts)
+
+		return &layers.TCP{SYN: true, Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: mssData},
+			{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{ws}},
+			{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: tsData},
+		}}
+	}
+
+	values := func(tcp *layers.TCP) (uint16, uint8, uint32) {
+		return binary.BigEndian.Uint16(tcp.Options[0].OptionData),
+			tcp.Options[4].OptionData[0],
+			binary.BigEndian.Uint32(tcp.Options[2].OptionData)
+	}
+
+	// valid values are preserved
+	tcp := packet(1400, 7, 12345)
+	SpoofTcpOptionsWithHints(tcp, sig, HintPolicies{})
+	mss, ws, ts := values(tcp)
+	assert.Equal(t, uint16(1400), mss)
+	assert.Equal(t, uint8(7), ws)
+	assert.Equal(t, uint32(12345), ts)
+
+	// invalid values are replaced
+	tcp = packet(100, 20, 12345)
+	SpoofTcpOptionsWithHints(tcp, sig, HintPolicies{})
+	mss, ws, _ = values(tcp)
+	assert.GreaterOrEqual(t, mss, uint16(536))
+	assert.LessOrEqual(t, mss, uint16(0xFFFF/20))
+	assert.LessOrEqual(t, ws, uint8(14))
+
+	// random values are valid
+	tcp = packet(1400, 7, 12345)
+	SpoofTcpOptionsWithHints(tcp, sig, HintPolicies{MSS: HintRandomize, WindowScale: HintRandomize, Timestamps: HintRandomize})
+	mss, ws, ts = values(tcp)
+	assert.GreaterOrEqual(t, mss, uint16(536))
+	assert.LessOrEqual(t, mss, uint16(0xFFFF/20))
+	assert.LessOrEqual(t, ws, uint8(14))
+	assert.NotEqual(t, uint32(0), ts)
+
+	// configured values are used as is
+	tcp = packet(1400, 7, 12345)
+	SpoofTcpOptionsWithHints(tcp, sig, HintPolicies{
+		MSS: HintFromConfig, MSSValue: 100,
+		WindowScale: HintFromConfig, WindowScaleValue: 20,
+		Timestamps: HintFromConfig, TimestampValue: 1,
+	})
+	mss, ws, ts = values(tcp)
+	assert.Equal(t, uint16(100), mss)
+	assert.Equal(t, uint8(20), ws)
+	assert.Equal(t, uint32(1), ts)
+}
@@ -0,0 +1,54 @@
+package p0f
+
+import (
+	"errors"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"math/rand"
+)
+
+var (
+	ErrPayloadNotAllowed = errors.New("signature requires zero payload")
+	ErrPayloadRequired   = errors.New("signature requires non-zero payload")
+)
+
+// SpoofTcpPayload makes TCP payload match payload size class of the signature.
+// The payload is set to tcp.Payload, so it must be serialized from there,
+// e.g. gopacket.SerializeLayers(buf, opts, ipLayer, tcpLayer, gopacket.Payload(tcpLayer.Payload)).
+//
+//	"0" - payload is stripped, error is returned if non-empty payload is given
+//	"+" - given payload is attached, packet payload is kept if nothing is given,
+//	      error is returned if there is no payload at all
+//	"*" - given payload is attached, packet payload is kept if nothing is given
+func SpoofTcpPayload(tcp *layers.TCP, sig *signature.Signature, payload []byte) error {
+
+	switch sig.PayloadSize {
+	case signature.PayloadSizeZero:
+		if len(payload) > 0 {
+			return ErrPayloadNotAllowed
+		}
+		tcp.Payload = nil
+		return nil
+
+	case signature.PayloadSizeNonZero:
+		if len(payload) == 0 && len(tcp.Payload) == 0 {
+			return ErrPayloadRequired
+		}
+	}
+
+	if len(payload) > 0 {
+		tcp.Payload = payload
+	}
+
+	return nil
+}
+
+// SyntheticPayload returns random data of the given size to be sent with SYN,
+// like TCP Fast Open does, for signatures with non-zero payload size class
+func SyntheticPayload(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = uint8(rand.Intn(0xFF) + 1)
+	}
+	return payload
+}
@@ -0,0 +1,47 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSpoofTcpPayload(t *testing.T) {
+
+	data := []byte("GET / HTTP/1.1\r\n")
+	synthetic := SyntheticPayload(16)
+	assert.Len(t, synthetic, 16)
+	assert.NotContains(t, synthetic, uint8(0))
+
+	var testData = []struct {
+		pclass   signature.PayloadSize
+		packet   []byte
+		payload  []byte
+		expected []byte
+		err      error
+	}{
+		{signature.PayloadSizeZero, data, nil, nil, nil},
+		{signature.PayloadSizeZero, nil, data, nil, ErrPayloadNotAllowed},
+		{signature.PayloadSizeNonZero, nil, nil, nil, ErrPayloadRequired},
+		{signature.PayloadSizeNonZero, data, nil, data, nil},
+		{signature.PayloadSizeNonZero, data, synthetic, synthetic, nil},
+		{signature.PayloadSizeNonZero, nil, synthetic, synthetic, nil},
+		{signature.PayloadSizeAny, nil, nil, nil, nil},
+		{signature.PayloadSizeAny, data, nil, data, nil},
+		{signature.PayloadSizeAny, nil, data, data, nil},
+	}
+
+	for _, item := range testData {
+		tcp := &layers.TCP{SYN: true}
+		tcp.Payload = item.packet
+
+		err := SpoofTcpPayload(tcp, &signature.Signature{PayloadSize: item.pclass}, item.payload)
+		if item.err != nil {
+			assert.ErrorIs(t, err, item.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, item.expected, tcp.Payload)
+	}
+}
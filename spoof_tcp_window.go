@@ -6,27 +6,46 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
+// SpoofTcpWindow sets window size of the signature, it must be called after SpoofTcpOptions
+// for "mss*N" and "mtu*N" window sizes. MTU is calculated for IPv4. MSS of the packet without
+// MSS option is zero, the same as in Observe.
 func SpoofTcpWindow(tcp *layers.TCP, sig *signature.Signature) {
+	spoofTcpWindow(tcp, sig, signature.IpVersion4, globalRandom{})
+}
+
+func spoofTcpWindow(tcp *layers.TCP, sig *signature.Signature, version signature.IpVersion, rnd random) {
 
 	switch sig.WindowSize.WindowSizeType {
 	case signature.WindowTypeNormal:
 		tcp.Window = sig.WindowSize.WindowSize
 		return
 	case signature.WindowTypeMSS:
-		for _, option := range tcp.Options {
-			if option.OptionType == layers.TCPOptionKindMSS {
-				if len(option.OptionData) >= 2 {
-					tcp.Window = binary.BigEndian.Uint16(option.OptionData) * sig.WindowSize.WindowSize
-					return
-				}
-			}
-		}
-		panic("TCP window value requires MSS, but MSS option is not set on packet")
+		tcp.Window = uint16(tcpMSS(tcp) * int(sig.WindowSize.WindowSize))
+		return
 	case signature.WindowTypeMTU:
-		// TODO
+		mtu := signature.MTU(tcpMSS(tcp), version)
+		tcp.Window = uint16(mtu * int(sig.WindowSize.WindowSize))
+		return
 	case signature.WindowTypeMod:
-		// TODO
+		// window of the packet is kept if it is a multiple of N
+		n := int(sig.WindowSize.WindowSize)
+		if tcp.Window == 0 || int(tcp.Window)%n != 0 {
+			tcp.Window = uint16(n * (rnd.Intn(0xFFFF/n) + 1))
+		}
+		return
 	}
 
 	// WindowTypeAny
 }
+
+// tcpMSS returns MSS option of the packet, zero if it is not set
+func tcpMSS(tcp *layers.TCP) int {
+	for _, option := range tcp.Options {
+		if option.OptionType == layers.TCPOptionKindMSS {
+			if len(option.OptionData) >= 2 {
+				return int(binary.BigEndian.Uint16(option.OptionData))
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,484 @@
+package p0f
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"math/rand"
+	"strings"
+)
+
+var (
+	ErrSignatureMismatch = errors.New("packet does not match signature")
+	ErrIpVersion         = errors.New("signature does not allow IP version")
+	ErrNotTcpPacket      = errors.New("not a TCP packet")
+)
+
+// MismatchError is returned by strict Spoofer if the spoofed packet does not match the signature,
+// e.g. if signature requires payload or the packet has IP options
+type MismatchError struct {
+	Signature  *signature.Signature
+	Mismatches []signature.Mismatch
+}
+
+func (e *MismatchError) Error() string {
+
+	mismatches := make([]string, 0, len(e.Mismatches))
+	for _, mismatch := range e.Mismatches {
+		mismatches = append(mismatches, mismatch.String())
+	}
+
+	return fmt.Sprintf("%s '%s': %s", ErrSignatureMismatch, e.Signature, strings.Join(mismatches, ", "))
+}
+
+func (e *MismatchError) Unwrap() error {
+	return ErrSignatureMismatch
+}
+
+// SpooferOption configures Spoofer
+type SpooferOption func(s *Spoofer)
+
+// WithSignature makes Spoofer use the signature for all packets
+func WithSignature(sig *signature.Signature) SpooferOption {
+	return func(s *Spoofer) {
+		s.sig = sig
+	}
+}
+
+// WithProfile makes Spoofer use random signature of the registry label, see ProfileRegistry.Choose.
+// IP identification and ISN generators of the profile are used unless they are set by options.
+func WithProfile(registry *ProfileRegistry, label string) SpooferOption {
+	return func(s *Spoofer) {
+		s.registry = registry
+		s.label = label
+	}
+}
+
+// WithRandSource sets source of random values, global source of math/rand is used by default.
+// IP identification generators of the package take their random values from it as well.
+func WithRandSource(src rand.Source) SpooferOption {
+	return func(s *Spoofer) {
+		s.rnd = newLockedRandom(src)
+	}
+}
+
+// WithTTLDistance sets TTL to initial TTL of the signature minus number of hops, zero by default
+func WithTTLDistance(hops int) SpooferOption {
+	return func(s *Spoofer) {
+		s.distance = hops
+	}
+}
+
+// WithHintPolicies sets policies of using values of the packet options
+func WithHintPolicies(hints HintPolicies) SpooferOption {
+	return func(s *Spoofer) {
+		s.hints = hints
+	}
+}
+
+// WithStrict makes Spoofer verify every packet and return *MismatchError
+// if the packet does not match the signature
+func WithStrict(strict bool) SpooferOption {
+	return func(s *Spoofer) {
+		s.strict = strict
+	}
+}
+
+// WithIpIdGenerator sets IP identification generator, ID of the packet is kept by default
+func WithIpIdGenerator(generator IpIdGenerator) SpooferOption {
+	return func(s *Spoofer) {
+		s.ipId = generator
+	}
+}
+
+// WithIsnGenerator sets ISN generator, sequence number of the packet is kept by default
+func WithIsnGenerator(generator IsnGenerator) SpooferOption {
+	return func(s *Spoofer) {
+		s.isn = generator
+	}
+}
+
+// WithLink makes Spoofer choose MSS of a random MTU of the link label of the database, e.g. "DSL",
+// for signatures with "*" MSS, see signature.Database.LinkMSS. MTUs which do not keep "mss*N" window
+// size within 16 bits are skipped, and MSS is random if none is left. The link takes precedence
+// over MSS hint policy. NewSpoofer fails if the database is nil or has no MTU of the label.
+func WithLink(db *signature.Database, label string) SpooferOption {
+	return func(s *Spoofer) {
+		s.linkDb = db
+		s.linkLabel = label
+	}
+}
+
+// WithPayload makes Spoofer attach the payload to packets without payload if signature requires
+// non-zero payload ("+" payload size class), see SpoofTcpPayload. Payload is not copied.
+func WithPayload(payload []byte) SpooferOption {
+	return func(s *Spoofer) {
+		s.payload = func(_ random) []byte {
+			return payload
+		}
+	}
+}
+
+// WithSyntheticPayload is WithPayload with new random data of the given size for every packet,
+// see SyntheticPayload
+func WithSyntheticPayload(size int) SpooferOption {
+	return func(s *Spoofer) {
+		s.payload = func(rnd random) []byte {
+			payload := make([]byte, size)
+			for i := range payload {
+				payload[i] = uint8(rnd.Intn(0xFF) + 1)
+			}
+			return payload
+		}
+	}
+}
+
+// Spoofer rewrites packets to match signature, it is safe for concurrent use.
+// Free functions (SpoofIpLayer, SpoofTcpLayer, SpoofEcn) are the same with default options.
+type Spoofer struct {
+	sig      *signature.Signature
+	registry *ProfileRegistry
+	label    string
+	rnd      random
+	distance int
+	hints    HintPolicies
+	strict   bool
+	ipId     IpIdGenerator
+	isn      IsnGenerator
+	// link of WithLink and its MTUs
+	linkDb    *signature.Database
+	linkLabel string
+	linkMTUs  []int
+	// payload of WithPayload or WithSyntheticPayload
+	payload func(rnd random) []byte
+}
+
+// NewSpoofer creates Spoofer, either WithSignature or WithProfile option is required
+func NewSpoofer(options ...SpooferOption) (*Spoofer, error) {
+
+	s := &Spoofer{rnd: globalRandom{}}
+	for _, option := range options {
+		option(s)
+	}
+
+	if (s.sig == nil) == (s.registry == nil) {
+		return nil, errors.New("either signature or profile is required")
+	}
+
+	if s.linkDb != nil || s.linkLabel != "" {
+		if s.linkDb == nil {
+			return nil, fmt.Errorf("database of link %q is required", s.linkLabel)
+		}
+		for _, link := range s.linkDb.Links {
+			if strings.EqualFold(link.Label, s.linkLabel) {
+				s.linkMTUs = append(s.linkMTUs, link.MTU)
+			}
+		}
+		if len(s.linkMTUs) == 0 {
+			return nil, fmt.Errorf("unknown link %q", s.linkLabel)
+		}
+	}
+
+	if s.distance < 0 || s.distance > signature.MaxDistance {
+		return nil, fmt.Errorf("TTL distance %d is out of range 0..%d", s.distance, signature.MaxDistance)
+	}
+
+	if s.registry != nil {
+		ipId, err := s.registry.IpIdGenerator(s.label)
+		if err != nil {
+			return nil, err
+		}
+		isn, _ := s.registry.IsnGenerator(s.label)
+
+		if s.ipId == nil {
+			s.ipId = ipId
+		}
+		if s.isn == nil {
+			s.isn = isn
+		}
+	}
+
+	return s, nil
+}
+
+// Signature returns signature of the next connection, it is random signature of the profile
+// if Spoofer is created WithProfile. Use the same signature for all packets of the connection.
+func (s *Spoofer) Signature() (*signature.Signature, error) {
+	return s.signature(s.rnd)
+}
+
+func (s *Spoofer) signature(rnd random) (*signature.Signature, error) {
+
+	if s.sig != nil {
+		return s.sig, nil
+	}
+
+	record, err := s.registry.chooseRecord(s.label, rnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Signature, nil
+}
+
+// SpoofIPv4 rewrites IPv4 layer, see SpoofIpLayer. TTL is set from initial TTL and TTL distance.
+func (s *Spoofer) SpoofIPv4(ipv4 *layers.IPv4, sig *signature.Signature) error {
+	return s.spoofIPv4(ipv4, sig, s.rnd)
+}
+
+func (s *Spoofer) spoofIPv4(ipv4 *layers.IPv4, sig *signature.Signature, rnd random) error {
+
+	if sig.IpVersion == signature.IpVersion6 {
+		return fmt.Errorf("%w: %s", ErrIpVersion, signature.IpVersion4)
+	}
+
+	spoofIpLayer(ipv4, sig, s.ipId, rnd)
+	ipv4.TTL = s.ttl(sig)
+	return nil
+}
+
+// SpoofIPv6 rewrites IPv6 layer, see SpoofIpv6Layer. Hop limit is set from initial TTL and TTL distance.
+func (s *Spoofer) SpoofIPv6(ipv6 *layers.IPv6, sig *signature.Signature) error {
+	return s.spoofIPv6(ipv6, sig, s.rnd)
+}
+
+func (s *Spoofer) spoofIPv6(ipv6 *layers.IPv6, sig *signature.Signature, rnd random) error {
+
+	if sig.IpVersion == signature.IpVersion4 {
+		return fmt.Errorf("%w: %s", ErrIpVersion, signature.IpVersion6)
+	}
+
+	spoofIpv6Layer(ipv6, sig, rnd)
+	ipv6.HopLimit = s.ttl(sig)
+	return nil
+}
+
+// SpoofTCP rewrites TCP layer, see SpoofTcpLayerWithHints. Window size "mtu*N" is calculated
+// for IPv4 unless signature is of IPv6. The layer is not changed if an error is returned.
+func (s *Spoofer) SpoofTCP(tcp *layers.TCP, sig *signature.Signature) error {
+
+	version := signature.IpVersion4
+	if sig.IpVersion == signature.IpVersion6 {
+		version = signature.IpVersion6
+	}
+
+	if err := checkWindow(sig, version); err != nil {
+		return err
+	}
+
+	if err := spoofTcpLayer(tcp, sig, s.tcpHints(sig, version, s.rnd), version, s.rnd, nil); err != nil {
+		return err
+	}
+	s.spoofPayload(tcp, sig, s.rnd)
+	return nil
+}
+
+// SpoofLayers rewrites IPv4 or IPv6 layer and TCP layer of the same packet, ECN and ISN of both layers
+// are consistent. Strict Spoofer verifies the packet. The layers are not changed if an error
+// other than *MismatchError is returned.
+func (s *Spoofer) SpoofLayers(ip gopacket.NetworkLayer, tcp *layers.TCP, sig *signature.Signature) error {
+	return s.spoofLayers(ip, tcp, sig, s.rnd, nil)
+}
+
+func (s *Spoofer) spoofLayers(ip gopacket.NetworkLayer, tcp *layers.TCP, sig *signature.Signature, rnd random, buffer *tcpOptionsBuffer) error {
+
+	// the signature is validated before any layer is changed
+	var version signature.IpVersion
+	switch ip.(type) {
+	case *layers.IPv4:
+		version = signature.IpVersion4
+	case *layers.IPv6:
+		version = signature.IpVersion6
+	default:
+		return fmt.Errorf("%w: %s", ErrIpVersion, ip.LayerType())
+	}
+
+	if sig.IpVersion != signature.IpVersionAny && sig.IpVersion != version {
+		return fmt.Errorf("%w: %s", ErrIpVersion, version)
+	}
+
+	if err := checkWindow(sig, version); err != nil {
+		return err
+	}
+
+	// options are the only part which may not fit, TCP layer is restored in this case
+	if err := spoofTcpLayer(tcp, sig, s.tcpHints(sig, version, rnd), version, rnd, buffer); err != nil {
+		return err
+	}
+	s.spoofPayload(tcp, sig, rnd)
+
+	var mismatches []signature.Mismatch
+
+	// IP version is checked above, so IP layers are always spoofed
+	switch ip := ip.(type) {
+	case *layers.IPv4:
+		_ = s.spoofIPv4(ip, sig, rnd)
+		SpoofEcn(ip, tcp, sig)
+		if s.isn != nil {
+			SpoofTcpIsn(ip, tcp, sig, s.isn)
+		}
+		if s.strict {
+			mismatches = Verify(ip, tcp, sig)
+		}
+
+	case *layers.IPv6:
+		_ = s.spoofIPv6(ip, sig, rnd)
+		spoofTcpEcnFlags(tcp, sig)
+		ip.TrafficClass = spoofEcnBits(ip.TrafficClass, tcp, sig)
+		if s.isn != nil {
+			SpoofTcpIsn(ip, tcp, sig, s.isn)
+		}
+		if s.strict {
+			mismatches = VerifyIpv6(ip, tcp, sig)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return &MismatchError{Signature: sig, Mismatches: mismatches}
+	}
+
+	return nil
+}
+
+// SpoofPacket rewrites IPv4 or IPv6 packet with TCP layer and returns serialized packet.
+// Signature is chosen for every packet, so use Signature and SpoofLayers
+// to keep signature of the connection if Spoofer is created WithProfile.
+func (s *Spoofer) SpoofPacket(data []byte) ([]byte, error) {
+
+	if len(data) == 0 {
+		return nil, ErrNotTcpPacket
+	}
+
+	sig, err := s.Signature()
+	if err != nil {
+		return nil, err
+	}
+
+	firstLayer := layers.LayerTypeIPv4
+	if data[0]>>4 == 6 {
+		firstLayer = layers.LayerTypeIPv6
+	}
+
+	packet := gopacket.NewPacket(data, firstLayer, gopacket.Default)
+	if errLayer := packet.ErrorLayer(); errLayer != nil {
+		return nil, errLayer.Error()
+	}
+
+	ip := packet.NetworkLayer()
+	tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if ip == nil || !ok {
+		return nil, ErrNotTcpPacket
+	}
+
+	if err := s.SpoofLayers(ip, tcp, sig); err != nil {
+		return nil, err
+	}
+
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		return nil, err
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+
+	err = gopacket.SerializeLayers(buf, opts, ip.(gopacket.SerializableLayer), ExactTcpLayer{TCP: tcp}, gopacket.Payload(tcp.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// spoofPayload attaches payload of the option to the packet without payload if signature requires it,
+// payload of "0" signatures is stripped by spoofTcpLayer
+func (s *Spoofer) spoofPayload(tcp *layers.TCP, sig *signature.Signature, rnd random) {
+
+	if s.payload == nil || sig.PayloadSize != signature.PayloadSizeNonZero || len(tcp.Payload) > 0 {
+		return
+	}
+
+	// payload is not empty, so it is always accepted
+	_ = SpoofTcpPayload(tcp, sig, s.payload(rnd))
+}
+
+// tcpHints returns hint policies with MSS of random MTU of the link for the IP version
+func (s *Spoofer) tcpHints(sig *signature.Signature, version signature.IpVersion, rnd random) HintPolicies {
+
+	hints := s.hints
+	if len(s.linkMTUs) == 0 || sig.MaximumSegmentSize != signature.MaximumSegmentSizeWildcardIntValue {
+		return hints
+	}
+
+	_, maxMss, _ := mssRange(sig, version)
+	valid := func(mtu int) bool {
+		mss := mtu - signature.MTU(0, version)
+		return mss > 0 && mss <= maxMss
+	}
+
+	// MTUs are counted and chosen in two passes to not allocate for every packet
+	n := 0
+	for _, mtu := range s.linkMTUs {
+		if valid(mtu) {
+			n++
+		}
+	}
+	if n == 0 {
+		return hints
+	}
+
+	n = rnd.Intn(n)
+	for _, mtu := range s.linkMTUs {
+		if !valid(mtu) {
+			continue
+		}
+		if n == 0 {
+			hints.MSS = HintFromConfig
+			hints.MSSValue = uint16(mtu - signature.MTU(0, version))
+			break
+		}
+		n--
+	}
+
+	return hints
+}
+
+// checkWindow returns ErrWindowOverflow if "mss*N" or "mtu*N" window size of the signature
+// does not fit 16 bits for exact MSS of the signature or for any MSS of "*"
+func checkWindow(sig *signature.Signature, version signature.IpVersion) error {
+
+	mss := sig.MaximumSegmentSize
+	if mss == signature.MaximumSegmentSizeWildcardIntValue {
+		_, _, err := mssRange(sig, version)
+		return err
+	}
+
+	window := 0
+	switch sig.WindowSize.WindowSizeType {
+	case signature.WindowTypeMSS:
+		window = mss * int(sig.WindowSize.WindowSize)
+	case signature.WindowTypeMTU:
+		window = signature.MTU(mss, version) * int(sig.WindowSize.WindowSize)
+	}
+
+	if window > 0xFFFF {
+		return fmt.Errorf("%w: '%s'", ErrWindowOverflow, sig)
+	}
+
+	return nil
+}
+
+// ttl returns initial TTL of the signature decreased by distance
+func (s *Spoofer) ttl(sig *signature.Signature) uint8 {
+
+	ttl := sig.InitialTTL - s.distance
+	if ttl > 0xFF {
+		ttl = 0xFF
+	}
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	return uint8(ttl)
+}
@@ -0,0 +1,264 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewSpoofer(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("4:64:0:*:mss*10,0:mss:df,id+:0")
+	db, _ := parser.ParseDatabase(strings.NewReader(testProfiles))
+	registry := NewProfileRegistry(db.Request)
+
+	_, err := NewSpoofer()
+	assert.Error(t, err)
+	_, err = NewSpoofer(WithSignature(sig), WithProfile(registry, "Linux"))
+	assert.Error(t, err)
+	_, err = NewSpoofer(WithSignature(sig), WithTTLDistance(signature.MaxDistance+1))
+	assert.Error(t, err)
+	_, err = NewSpoofer(WithProfile(registry, "OpenBSD"))
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+
+	// generators of the profile
+	spoofer, err := NewSpoofer(WithProfile(registry, "Linux"))
+	assert.NoError(t, err)
+	assert.IsType(t, &PerDestinationIpId{}, spoofer.ipId)
+	assert.IsType(t, &RFC6528Isn{}, spoofer.isn)
+
+	spoofer, err = NewSpoofer(WithProfile(registry, "Linux"), WithIpIdGenerator(ZeroIpId{}))
+	assert.NoError(t, err)
+	assert.Equal(t, ZeroIpId{}, spoofer.ipId)
+
+	for i := 0; i < 100; i++ {
+		sig, err = spoofer.Signature()
+		assert.NoError(t, err)
+		assert.Contains(t, []*signature.Signature{db.Request[0].Signature, db.Request[1].Signature, db.Request[2].Signature}, sig)
+	}
+}
+
+func TestSpooferLayers(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+,ecn:0")
+
+	spoofer, err := NewSpoofer(WithSignature(sig), WithTTLDistance(10), WithStrict(true), WithIsnGenerator(NewIncrementalIsn()))
+	assert.NoError(t, err)
+
+	ipv4, tcp := randomPacket(false)
+	assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+	assert.Equal(t, uint8(54), ipv4.TTL)
+	assert.Empty(t, Verify(ipv4, tcp, sig))
+
+	ipv6 := &layers.IPv6{SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"), FlowLabel: 1}
+	_, tcp = randomPacket(false)
+	assert.NoError(t, spoofer.SpoofLayers(ipv6, tcp, sig))
+	assert.Equal(t, uint8(54), ipv6.HopLimit)
+	assert.Zero(t, ipv6.FlowLabel)
+	assert.Empty(t, VerifyIpv6(ipv6, tcp, sig))
+
+	// signature of another IP version
+	sig, _ = parser.Parse("6:64:0:*:mss*20,7:mss,sok,ts,nop,ws:flow:0")
+	ipv4, tcp = randomPacket(false)
+	ipv4Before := *ipv4
+	assert.ErrorIs(t, spoofer.SpoofLayers(ipv4, tcp, sig), ErrIpVersion)
+	assert.Equal(t, ipv4Before, *ipv4)
+
+	// options which do not fit TCP header, the layers are not changed
+	sig, _ = parser.Parse("4:64:0:*:65535,*:mss,?253,ts,ts,ts:df:0")
+	ipv4, tcp = randomPacket(false)
+	tcp.Options = []layers.TCPOption{{OptionType: 253, OptionLength: 12, OptionData: make([]byte, 10)}}
+	ipv4Before, tcpBefore := *ipv4, *tcp
+	assert.ErrorIs(t, spoofer.SpoofLayers(ipv4, tcp, sig), ErrOptionsOverflow)
+	assert.ErrorIs(t, spoofer.SpoofTCP(tcp, sig), ErrOptionsOverflow)
+	assert.Equal(t, ipv4Before, *ipv4)
+	assert.Equal(t, tcpBefore, *tcp)
+
+	// strict spoofer reports what can not be spoofed
+	sig, _ = parser.Parse("4:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:+")
+	ipv4, tcp = randomPacket(false)
+	err = spoofer.SpoofLayers(ipv4, tcp, sig)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+	var mismatchErr *MismatchError
+	assert.ErrorAs(t, err, &mismatchErr)
+	assert.Equal(t, signature.FieldPayloadSize, mismatchErr.Mismatches[0].Field)
+
+	lenient, _ := NewSpoofer(WithSignature(sig))
+	ipv4, tcp = randomPacket(false)
+	assert.NoError(t, lenient.SpoofLayers(ipv4, tcp, sig))
+
+	// payload is attached to packets of "+" signatures
+	payload := []byte("GET / HTTP/1.1\r\n\r\n")
+	for _, option := range []SpooferOption{WithPayload(payload), WithSyntheticPayload(16)} {
+		spoofer, err = NewSpoofer(WithSignature(sig), WithStrict(true), option)
+		assert.NoError(t, err)
+
+		ipv4, tcp = randomPacket(false)
+		assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+		assert.NotEmpty(t, tcp.Payload)
+		assert.Empty(t, Verify(ipv4, tcp, sig))
+
+		// payload of the packet is kept
+		ipv4, tcp = randomPacket(false)
+		tcp.Payload = []byte{1}
+		assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+		assert.Equal(t, []byte{1}, tcp.Payload)
+	}
+
+	// and not to packets of "0" signatures
+	zero, _ := parser.Parse("4:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df,id+:0")
+	ipv4, tcp = randomPacket(false)
+	assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, zero))
+	assert.Empty(t, tcp.Payload)
+}
+
+func TestSpooferRandSource(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:*:mss*20,*:mss,sok,ts,nop,ws:df,id+:0")
+
+	// the same source gives the same packets
+	var packets [2]*layers.TCP
+	for i := range packets {
+		spoofer, err := NewSpoofer(WithSignature(sig), WithRandSource(rand.NewSource(1)), WithHintPolicies(HintPolicies{MSS: HintRandomize}))
+		assert.NoError(t, err)
+
+		tcp := &layers.TCP{SYN: true, Seq: 1}
+		assert.NoError(t, spoofer.SpoofTCP(tcp, sig))
+		packets[i] = tcp
+	}
+
+	assert.Equal(t, packets[0].Options, packets[1].Options)
+	assert.Equal(t, packets[0].Window, packets[1].Window)
+}
+
+func TestSpooferWindowSize(t *testing.T) {
+
+	parser := signature.Parser{}
+
+	var testData = []struct {
+		sig      string
+		overflow bool
+	}{
+		// window size without MSS option is of zero MSS
+		{"4:64:0:*:mss*4,7:nop,ws:df:0", false},
+		{"4:64:0:*:mtu*4,7:nop,ws:df:0", false},
+		// MSS is lowered to keep window size within 16 bits
+		{"4:64:0:*:mss*2000,0:mss:df:0", false},
+		{"4:64:0:*:mtu*1000,0:mss:df:0", false},
+		// window size overflows for any MSS
+		{"4:64:0:*:mtu*2000,0:mss:df:0", true},
+		{"4:64:0:1460:mss*50,0:mss:df:0", true},
+	}
+
+	for _, item := range testData {
+		sig, err := parser.Parse(item.sig)
+		assert.NoError(t, err)
+
+		spoofer, err := NewSpoofer(WithSignature(sig), WithStrict(true))
+		assert.NoError(t, err)
+
+		ipv4, tcp := randomPacket(false)
+		ipv4Before, tcpBefore := *ipv4, *tcp
+		err = spoofer.SpoofLayers(ipv4, tcp, sig)
+		if item.overflow {
+			assert.ErrorIs(t, err, ErrWindowOverflow, item.sig)
+			assert.ErrorIs(t, spoofer.SpoofTCP(tcp, sig), ErrWindowOverflow, item.sig)
+			// layers are not changed
+			assert.Equal(t, ipv4Before, *ipv4, item.sig)
+			assert.Equal(t, tcpBefore, *tcp, item.sig)
+			// free functions do not fail
+			SpoofTcpLayer(tcp, sig)
+		} else {
+			assert.NoError(t, err, item.sig)
+			assert.Empty(t, Verify(ipv4, tcp, sig), item.sig)
+		}
+	}
+}
+
+func TestSpooferLink(t *testing.T) {
+
+	parser := signature.Parser{}
+	sig, _ := parser.Parse("*:64:0:*:mss*20,7:mss,sok,ts,nop,ws:df:0")
+	db := signature.DefaultDatabase()
+
+	_, err := NewSpoofer(WithSignature(sig), WithLink(db, "carrier pigeon"))
+	assert.Error(t, err)
+	_, err = NewSpoofer(WithSignature(sig), WithLink(nil, "dsl"))
+	assert.Error(t, err)
+	_, err = NewSpoofer(WithSignature(sig), WithLink(db, ""))
+	assert.Error(t, err)
+
+	spoofer, err := NewSpoofer(WithSignature(sig), WithLink(db, "dsl"), WithStrict(true))
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		ipv4, tcp := randomPacket(false)
+		assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+		observed := Observe(ipv4, tcp)
+		if link := db.Link(observed.MaximumSegmentSize, signature.IpVersion4); assert.NotNil(t, link) {
+			assert.Equal(t, "DSL", link.Label)
+		}
+	}
+
+	// MSS of jumbo Ethernet does not fit window size, so it is random
+	spoofer, err = NewSpoofer(WithSignature(sig), WithLink(db, "jumbo Ethernet"), WithStrict(true))
+	assert.NoError(t, err)
+
+	ipv4, tcp := randomPacket(false)
+	assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+	assert.LessOrEqual(t, Observe(ipv4, tcp).MaximumSegmentSize, 0xFFFF/20)
+
+	// exact MSS of the signature takes precedence
+	exact, _ := parser.Parse("*:64:0:1460:mss*20,7:mss,sok,ts,nop,ws:df:0")
+	ipv4, tcp = randomPacket(false)
+	assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, exact))
+	assert.Equal(t, 1460, Observe(ipv4, tcp).MaximumSegmentSize)
+}
+
+func TestSpooferPacket(t *testing.T) {
+
+	parser := signature.Parser{}
+	db, _ := parser.ParseDatabase(strings.NewReader(testProfiles))
+	registry := NewProfileRegistry(db.Request)
+
+	spoofer, err := NewSpoofer(WithProfile(registry, "Linux"), WithStrict(true), WithRandSource(rand.NewSource(1)))
+	assert.NoError(t, err)
+
+	ipv4, tcp := randomPacket(false)
+	data := serializeTestPacket(t, ipv4, tcp)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				result, err := spoofer.SpoofPacket(data)
+				assert.NoError(t, err)
+
+				packet := gopacket.NewPacket(result, layers.LayerTypeIPv4, gopacket.Default)
+				decodedIpv4 := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+				decodedTcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+				matched := false
+				for _, record := range db.Request[:3] {
+					matched = matched || len(Verify(decodedIpv4, decodedTcp, record.Signature)) == 0
+				}
+				assert.True(t, matched)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err = spoofer.SpoofPacket([]byte{0x45, 0})
+	assert.Error(t, err)
+}
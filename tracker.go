@@ -0,0 +1,320 @@
+package p0f
+
+import (
+	"cmp"
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of packets kept per host, the oldest are dropped
+	trackerMaxSamples = 64
+
+	// difference of distances of the same host caused by changes of routes
+	trackerDistanceTolerance = 2
+)
+
+// HostFlag is a bitmask of signs that several hosts share the address, see Tracker
+type HostFlag int
+
+const (
+	// packets match signatures of different operating systems
+	HostMultipleOS HostFlag = 1 << iota
+	// packets have different layouts of TCP options, that is different TCP stacks,
+	// which is seen even if signatures are not known
+	HostMultipleLayouts
+	// distances of packets differ more than routes change
+	HostMultipleDistances
+	// timestamps of packets are not of the same clock
+	HostMultipleClocks
+)
+
+func (f HostFlag) String() string {
+
+	var names []string
+	for _, flag := range []struct {
+		flag HostFlag
+		name string
+	}{
+		{HostMultipleOS, "os"},
+		{HostMultipleLayouts, "layouts"},
+		{HostMultipleDistances, "distances"},
+		{HostMultipleClocks, "clocks"},
+	} {
+		if f&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// HostReport is the state of the source address of the packet, see Tracker.Observe
+type HostReport struct {
+	Address netip.Addr
+	// SYN (request) packets of the address are tracked apart from its SYN+ACK (response) packets
+	Direction signature.Direction
+	// matched record of the packet, nil if the signature is not known
+	Record *signature.Record
+	Info   *HostInfo
+	// labels of matched records of packets of the address in order of appearance
+	Labels []string
+	// number of packets of the address kept by the tracker
+	Samples int
+	Flags   HostFlag
+}
+
+// Tracker keeps fingerprints of source addresses over time and flags addresses which show
+// several operating systems, TCP stacks, distances or clocks of timestamps, that is NAT,
+// proxies or load balancers. SYN and SYN+ACK packets of the address are compared separately,
+// as a server opening outbound connections sends both of them. Packets older than the window
+// are forgotten. Tracker is safe for concurrent use.
+type Tracker struct {
+	mu     sync.Mutex
+	db     *signature.Database
+	window time.Duration
+	hosts  map[trackerKey]*trackedHost
+	pruned time.Time
+}
+
+type trackerKey struct {
+	address   netip.Addr
+	direction signature.Direction
+}
+
+type trackedHost struct {
+	// samples are in order of sequence numbers
+	samples []trackedSample
+	seq     uint64
+}
+
+type trackedSample struct {
+	seq    uint64
+	sample *Sample
+	record *signature.Record
+	info   *HostInfo
+	layout string
+	// sequence numbers of earlier samples whose timestamps are not of the same clock
+	clockConflicts []uint64
+}
+
+// NewTracker creates tracker matching packets with the database, packets are kept for the window.
+// Database may be nil, records of packets are not known then.
+func NewTracker(db *signature.Database, window time.Duration) *Tracker {
+	return &Tracker{db: db, window: window, hosts: make(map[trackerKey]*trackedHost)}
+}
+
+// Observe records SYN (request) or SYN+ACK (response) packet captured at the time
+// and returns report of its source address and direction
+func (t *Tracker) Observe(ip gopacket.NetworkLayer, tcp *layers.TCP, at time.Time) (*HostReport, error) {
+
+	sample, err := NewSample(ip, tcp, at)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := signature.DirectionRequest
+	if tcp.ACK {
+		direction = signature.DirectionResponse
+	}
+
+	var record *signature.Record
+	var sig *signature.Signature
+	if t.db != nil {
+		record = t.db.Match(sample.Observed, direction)
+	}
+	if record != nil {
+		sig = record.Signature
+	}
+
+	tracked := trackedSample{
+		sample: sample,
+		record: record,
+		info:   Analyze(t.db, sig, sample, nil),
+		layout: layoutKey(sample.Observed.OptionsLayout),
+	}
+
+	src, _ := ip.NetworkFlow().Endpoints()
+	address, _ := netip.AddrFromSlice(src.Raw())
+	// IPv4 layers which are not decoded may have 16 byte addresses
+	key := trackerKey{address: address.Unmap(), direction: direction}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(at)
+
+	host, found := t.hosts[key]
+	if !found {
+		host = &trackedHost{}
+		t.hosts[key] = host
+	}
+
+	host.expire(at, t.window)
+	if len(host.samples) >= trackerMaxSamples {
+		host.samples = slices.Delete(host.samples, 0, len(host.samples)-trackerMaxSamples+1)
+	}
+	host.add(tracked)
+
+	return host.report(key), nil
+}
+
+// Flagged returns reports of addresses with flags at the time, reports are of the last packets
+func (t *Tracker) Flagged(now time.Time) []*HostReport {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	var result []*HostReport
+	for key, host := range t.hosts {
+		if report := host.report(key); report.Flags != 0 {
+			result = append(result, report)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b *HostReport) int {
+		if order := a.Address.Compare(b.Address); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Direction, b.Direction)
+	})
+
+	return result
+}
+
+// prune removes expired packets and addresses at most once per window, the lock is held
+func (t *Tracker) prune(now time.Time) {
+
+	if now.Sub(t.pruned) <= t.window {
+		return
+	}
+
+	for key, host := range t.hosts {
+		if host.expire(now, t.window); len(host.samples) == 0 {
+			delete(t.hosts, key)
+		}
+	}
+	t.pruned = now
+}
+
+// expire removes packets captured before the window
+func (h *trackedHost) expire(now time.Time, window time.Duration) {
+	h.samples = slices.DeleteFunc(h.samples, func(s trackedSample) bool {
+		return now.Sub(s.sample.Time) > window
+	})
+}
+
+// add appends the sample, clock of timestamps is compared with every kept sample once here,
+// so reports do not compare pairs of samples again
+func (h *trackedHost) add(tracked trackedSample) {
+
+	tracked.seq = h.seq
+	h.seq++
+
+	clock := false
+	for i := len(h.samples) - 1; i >= 0; i-- {
+		other := h.samples[i]
+		frequency, ok := ClockFrequency(other.sample, tracked.sample)
+		if !ok && clockMeasurable(other.sample, tracked.sample) {
+			tracked.clockConflicts = append(tracked.clockConflicts, other.seq)
+		}
+
+		// uptime of the packet by the latest packet its clock can be measured with
+		if ok && !clock {
+			info := *tracked.info
+			info.setClock(frequency, tracked.sample.Timestamp)
+			tracked.info = &info
+			clock = true
+		}
+	}
+
+	h.samples = append(h.samples, tracked)
+}
+
+// contains reports whether the sample of the sequence number is kept
+func (h *trackedHost) contains(seq uint64) bool {
+	_, found := slices.BinarySearchFunc(h.samples, seq, func(s trackedSample, seq uint64) int {
+		return cmp.Compare(s.seq, seq)
+	})
+	return found
+}
+
+func (h *trackedHost) report(key trackerKey) *HostReport {
+
+	last := h.samples[len(h.samples)-1]
+	report := &HostReport{
+		Address:   key.address,
+		Direction: key.direction,
+		Record:    last.record,
+		Info:      last.info,
+		Samples:   len(h.samples),
+	}
+
+	names := make(map[string]bool)
+	layouts := make(map[string]bool)
+	minDistance, maxDistance := last.info.Distance, last.info.Distance
+
+	for _, s := range h.samples {
+		layouts[s.layout] = true
+		minDistance = min(minDistance, s.info.Distance)
+		maxDistance = max(maxDistance, s.info.Distance)
+
+		if s.record != nil {
+			names[s.record.Label.Class+":"+s.record.Label.Name] = true
+			if label := s.record.Label.String(); !slices.Contains(report.Labels, label) {
+				report.Labels = append(report.Labels, label)
+			}
+		}
+
+		// timestamps of every pair which can be measured are of the same clock
+		for _, seq := range s.clockConflicts {
+			if h.contains(seq) {
+				report.Flags |= HostMultipleClocks
+				break
+			}
+		}
+	}
+
+	if len(names) > 1 {
+		report.Flags |= HostMultipleOS
+	}
+	if len(layouts) > 1 {
+		report.Flags |= HostMultipleLayouts
+	}
+	if maxDistance-minDistance > trackerDistanceTolerance {
+		report.Flags |= HostMultipleDistances
+	}
+
+	return report
+}
+
+// layoutKey returns option kinds as a string, "eol+n" is EOL followed by n more EOL kinds
+func layoutKey(layout []layers.TCPOptionKind) string {
+
+	key := make([]byte, len(layout))
+	for i, kind := range layout {
+		key[i] = byte(kind)
+	}
+
+	return string(key)
+}
+
+// clockMeasurable reports whether packets have timestamps and are captured at interval
+// which allows to measure clock frequency, see ClockFrequency
+func clockMeasurable(first *Sample, second *Sample) bool {
+
+	if !first.HasTimestamp || !second.HasTimestamp {
+		return false
+	}
+
+	interval := second.Time.Sub(first.Time).Abs()
+	return interval >= minClockInterval && interval <= maxClockInterval
+}
@@ -0,0 +1,109 @@
+package p0f
+
+import (
+	"github.com/alytsin/go-p0f/signature"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+
+	parser := signature.Parser{}
+	linux, _ := parser.Parse("*:64:0:*:mss*20,10:mss,sok,ts,nop,ws:df,id+:0")
+	windows, _ := parser.Parse("*:128:0:*:8192,8:mss,nop,ws,nop,nop,sok:df,id+:0")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(signature.DefaultDatabase(), time.Hour)
+
+	observe := func(src net.IP, sig *signature.Signature, hops int, ts uint32, at time.Duration) *HostReport {
+		spoofer, err := NewSpoofer(
+			WithSignature(sig),
+			WithTTLDistance(hops),
+			WithHintPolicies(HintPolicies{Timestamps: HintFromConfig, TimestampValue: ts}),
+		)
+		assert.NoError(t, err)
+
+		ipv4, tcp := randomPacket(false)
+		ipv4.SrcIP = src
+		assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, sig))
+
+		report, err := tracker.Observe(ipv4, tcp, start.Add(at))
+		assert.NoError(t, err)
+		return report
+	}
+
+	// the same host: one OS, distance, and clock of 1000 Hz
+	host := net.IPv4(10, 0, 0, 1)
+	report := observe(host, linux, 5, 1_000_000, 0)
+	assert.Equal(t, netip.MustParseAddr("10.0.0.1"), report.Address)
+	assert.Equal(t, []string{"s:unix:Linux:3.11 and newer"}, report.Labels)
+	assert.Equal(t, 5, report.Info.Distance)
+	assert.Zero(t, report.Flags)
+
+	report = observe(host, linux, 6, 1_030_000, 30*time.Second)
+	assert.Zero(t, report.Flags)
+	assert.Equal(t, 2, report.Samples)
+	assert.Equal(t, 1000, report.Info.ClockFrequency)
+	assert.Equal(t, 17*time.Minute+10*time.Second, report.Info.Uptime)
+
+	// NAT: Linux and Windows hosts at different distances and clocks
+	nat := net.IPv4(10, 0, 0, 2)
+	observe(nat, linux, 5, 1_000_000, 0)
+	report = observe(nat, linux, 5, 5_000_000, time.Second)
+	assert.Equal(t, HostMultipleClocks, report.Flags)
+
+	report = observe(nat, windows, 12, 0, 2*time.Second)
+	assert.Equal(t, HostMultipleOS|HostMultipleLayouts|HostMultipleDistances|HostMultipleClocks, report.Flags)
+	assert.Equal(t, "os,layouts,distances,clocks", report.Flags.String())
+	assert.Equal(t, []string{"s:unix:Linux:3.11 and newer", "s:win:Windows:7 or 8"}, report.Labels)
+	assert.Equal(t, "Windows", report.Record.Label.Name)
+
+	flagged := tracker.Flagged(start.Add(time.Minute))
+	if assert.Len(t, flagged, 1) {
+		assert.Equal(t, netip.MustParseAddr("10.0.0.2"), flagged[0].Address)
+	}
+
+	// server answering with SYN+ACK and opening outbound connections
+	response, _ := parser.Parse("*:64:0:*:mss*10,0:mss,sok,ts:df:0")
+	server := net.IPv4(10, 0, 0, 3)
+	observe(server, linux, 5, 1_000_000, 0)
+	ipv4, tcp := randomPacket(true)
+	ipv4.SrcIP = server
+	spoofer, _ := NewSpoofer(WithSignature(response), WithTTLDistance(5))
+	assert.NoError(t, spoofer.SpoofLayers(ipv4, tcp, response))
+	report, err := tracker.Observe(ipv4, tcp, start.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, signature.DirectionResponse, report.Direction)
+	assert.Equal(t, 1, report.Samples)
+	assert.Zero(t, report.Flags)
+
+	// packets out of the window are forgotten
+	assert.Empty(t, tracker.Flagged(start.Add(2*time.Hour)))
+	report = observe(nat, windows, 12, 0, 2*time.Hour)
+	assert.Equal(t, 1, report.Samples)
+	assert.Zero(t, report.Flags)
+}
+
+func TestTrackerIpv6(t *testing.T) {
+
+	tracker := NewTracker(signature.DefaultDatabase(), time.Minute)
+	ipv6 := &layers.IPv6{Version: 6, HopLimit: 60, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2")}
+
+	report, err := tracker.Observe(ipv6, &layers.TCP{SYN: true}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("2001:db8::1"), report.Address)
+	assert.Nil(t, report.Record)
+	assert.True(t, report.Info.GuessedTTL)
+	assert.Equal(t, 4, report.Info.Distance)
+
+	// database is optional
+	tracker = NewTracker(nil, time.Minute)
+	report, err = tracker.Observe(ipv6, &layers.TCP{SYN: true}, time.Now())
+	assert.NoError(t, err)
+	assert.Nil(t, report.Record)
+	assert.Nil(t, report.Info.Link)
+}